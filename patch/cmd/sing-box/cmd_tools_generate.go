@@ -3,19 +3,46 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"os"
 
 	"github.com/spf13/cobra"
 
 	"github.com/sagernet/sing-box/experimental/tools_generate"
 	"github.com/sagernet/sing-box/log"
+	E "github.com/sagernet/sing/common/exceptions"
 )
 
+var (
+	commandToolsGenerateFlagOffline bool
+	commandToolsGenerateFlagDryRun  bool
+	commandToolsGenerateFlagDiff    bool
+)
+
+// exit status of generate when the output is already up to date, while 0
+// means it has been rewritten, or would be with --dry-run or --diff
+const toolsGenerateExitUnchanged = 2
+
 var commandToolsGenerate = &cobra.Command{
 	Use:  "generate <config>",
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		err := toolsGenerate(args[0])
+		changed, err := toolsGenerate(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !changed {
+			os.Exit(toolsGenerateExitUnchanged)
+		}
+	},
+}
+
+var commandToolsGenerateTemplate = &cobra.Command{
+	Use:  "generate-template [output]",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := toolsGenerateTemplate(args)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -23,37 +50,77 @@ var commandToolsGenerate = &cobra.Command{
 }
 
 func init() {
+	commandToolsGenerate.Flags().BoolVar(&commandToolsGenerateFlagOffline, "offline", false, "Use cached subscriptions only")
+	commandToolsGenerate.Flags().BoolVar(&commandToolsGenerateFlagDryRun, "dry-run", false, "Print the generated config instead of writing it")
+	commandToolsGenerate.Flags().BoolVar(&commandToolsGenerateFlagDiff, "diff", false, "Show changes against the current output instead of writing it")
 	commandTools.AddCommand(commandToolsGenerate)
+	commandTools.AddCommand(commandToolsGenerateTemplate)
 }
 
-func toolsGenerate(configPath string) error {
-	configBytes, err := os.ReadFile(configPath)
-	if err != nil {
+func toolsGenerateTemplate(args []string) error {
+	if len(args) == 0 {
+		_, err := os.Stdout.WriteString(tools_generate.DefaultTemplate())
 		return err
 	}
+	return os.WriteFile(args[0], []byte(tools_generate.DefaultTemplate()), 0o644)
+}
 
-	config, err := tools_generate.Parse(configBytes)
+func toolsGenerate(configPath string) (bool, error) {
+	config, err := tools_generate.ParseFile(configPath)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if commandToolsGenerateFlagOffline {
+		config.Offline = true
 	}
 
-	singboxConfigBytes, err := tools_generate.GenerateSingBoxConfig(config)
+	generated, err := tools_generate.GenerateSingBoxConfig(globalCtx, config)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	singboxConfigFile, err := os.Create(config.SingBox.Output)
-	if err != nil {
-		return err
+	outputPath := config.OutputPath()
+	// previews leave the stable tags alone, as nothing is deployed
+	if commandToolsGenerateFlagDryRun || commandToolsGenerateFlagDiff {
+		return toolsGeneratePreview(outputPath, generated.Content)
 	}
-	defer func() {
-		_ = singboxConfigFile.Close()
-	}()
 
-	_, err = singboxConfigFile.Write(singboxConfigBytes)
+	changed, err := tools_generate.WriteOutput(outputPath, generated.Content, config.SingBox.Backups)
 	if err != nil {
-		return err
+		return false, err
+	}
+	err = generated.SaveStableTags()
+	if err != nil {
+		return false, E.Cause(err, "save stable tags")
+	}
+	if changed {
+		log.Info("written ", outputPath)
+	} else {
+		log.Info(outputPath, " is up to date")
 	}
+	return changed, nil
+}
 
-	return nil
+func toolsGeneratePreview(outputPath string, content []byte) (bool, error) {
+	current, err := os.ReadFile(outputPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	if commandToolsGenerateFlagDryRun {
+		_, err = os.Stdout.Write(content)
+		if err != nil {
+			return false, err
+		}
+	}
+	if commandToolsGenerateFlagDiff {
+		diff, err := tools_generate.DiffConfig(current, content)
+		if err != nil {
+			return false, err
+		}
+		_, err = os.Stdout.WriteString(diff)
+		if err != nil {
+			return false, err
+		}
+	}
+	return !bytes.Equal(current, content), nil
 }
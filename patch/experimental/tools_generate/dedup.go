@@ -0,0 +1,97 @@
+package tools_generate
+
+import (
+	"context"
+	"slices"
+
+	"github.com/sagernet/sing-box/log"
+	"github.com/sagernet/sing-box/option"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json"
+)
+
+const (
+	dedupKeepFirst      = "keep-first"
+	dedupKeepPriority   = "keep-priority"
+	dedupMergeIntoGroup = "merge-into-group"
+)
+
+func checkDedupPolicy(policy string) error {
+	switch policy {
+	case "", dedupKeepFirst, dedupKeepPriority, dedupMergeIntoGroup:
+		return nil
+	default:
+		return E.New("unknown dedup policy: ", policy)
+	}
+}
+
+// dedupNodes removes nodes whose options equal those of another node, tag
+// aside. With merge-into-group, the node kept is returned as a shared member
+// of the groups of the subscriptions its duplicates were removed from.
+func dedupNodes(ctx context.Context, config *Config, nodeList [][]*node) (shared [][]*node, err error) {
+	shared = make([][]*node, len(nodeList))
+	if config.Dedup == "" {
+		return
+	}
+	order := make([]int, len(nodeList))
+	for idx := range order {
+		order[idx] = idx
+	}
+	if config.Dedup == dedupKeepPriority {
+		slices.SortStableFunc(order, func(a, b int) int {
+			return config.SubscriptionList[b].Priority - config.SubscriptionList[a].Priority
+		})
+	}
+
+	type keptNode struct {
+		node         *node
+		subscription int
+	}
+	kept := make(map[string]keptNode)
+	for _, idx := range order {
+		var nodes []*node
+		for _, n := range nodeList[idx] {
+			identity, err := outboundIdentity(ctx, n.Outbound)
+			if err != nil {
+				return nil, E.Cause(err, "normalize ", n.Remark)
+			}
+			// via sets the detour later, so nodes reached through it are
+			// only duplicates of nodes reached the same way
+			if via := config.SubscriptionList[idx].Via; via != "" {
+				identity += " via " + via
+			}
+			first, loaded := kept[identity]
+			if !loaded {
+				kept[identity] = keptNode{n, idx}
+				nodes = append(nodes, n)
+				continue
+			}
+			log.Info("remove duplicate ", config.SubscriptionList[idx].Name, "/", n.Remark,
+				" of ", config.SubscriptionList[first.subscription].Name, "/", first.node.Remark)
+			if config.Dedup == dedupMergeIntoGroup && first.subscription != idx && !slices.Contains(shared[idx], first.node) {
+				shared[idx] = append(shared[idx], first.node)
+			}
+		}
+		nodeList[idx] = nodes
+	}
+	return
+}
+
+func outboundIdentity(ctx context.Context, outbound option.Outbound) (string, error) {
+	outbound.Tag = ""
+	content, err := outbound.MarshalJSONContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	// round trip through a map to sort keys
+	var value any
+	err = json.Unmarshal(content, &value)
+	if err != nil {
+		return "", err
+	}
+	content, err = json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
@@ -0,0 +1,36 @@
+package tools_generate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sagernet/sing-box/include"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupKeepsChainedNode(t *testing.T) {
+	ctx := include.Context(context.Background())
+	config := &Config{
+		Dedup: dedupMergeIntoGroup,
+		SubscriptionList: []subscriptionConfig{
+			{Name: "a"},
+			{Name: "b", Via: "out-entry"},
+			{Name: "c", Via: "out-entry"},
+		},
+	}
+	nodeList := [][]*node{
+		{relayNode("HK 01", "hk")},
+		{relayNode("HK 01", "hk")},
+		{relayNode("HK 01", "hk")},
+	}
+
+	shared, err := dedupNodes(ctx, config, nodeList)
+	require.NoError(t, err)
+	// b is chained while a is not, c is chained the same way as b
+	require.Len(t, nodeList[0], 1)
+	require.Len(t, nodeList[1], 1)
+	require.Empty(t, nodeList[2])
+	require.Empty(t, shared[1])
+	require.Equal(t, nodeList[1], shared[2])
+}
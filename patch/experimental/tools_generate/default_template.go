@@ -0,0 +1,12 @@
+package tools_generate
+
+import _ "embed"
+
+//go:embed default_template.json.tmpl
+var defaultTemplate string
+
+// DefaultTemplate returns the template used when sing-box.template is not
+// set, as a starting point for custom templates.
+func DefaultTemplate() string {
+	return defaultTemplate
+}
@@ -0,0 +1,290 @@
+package tools_generate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	C "github.com/sagernet/sing-box/constant"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json"
+)
+
+// DiffConfig describes what changes from the old config to the new one:
+// outbounds by tag, group members, rule sets and rules. An empty old config
+// is treated as having nothing. The result is empty when nothing changed.
+func DiffConfig(oldContent []byte, newContent []byte) (string, error) {
+	oldConfig, err := parseDiffConfig(oldContent)
+	if err != nil {
+		return "", E.Cause(err, "parse current config")
+	}
+	newConfig, err := parseDiffConfig(newContent)
+	if err != nil {
+		return "", E.Cause(err, "parse new config")
+	}
+
+	var d configDiff
+	oldOutbounds := taggedObjects(oldConfig, "outbounds", "endpoints")
+	newOutbounds := taggedObjects(newConfig, "outbounds", "endpoints")
+	for _, tag := range unionKeys(oldOutbounds, newOutbounds) {
+		oldOutbound, newOutbound := oldOutbounds[tag], newOutbounds[tag]
+		section := "outbounds"
+		if isGroup(oldOutbound) || isGroup(newOutbound) {
+			section = "groups"
+		}
+		switch {
+		case oldOutbound == nil:
+			d.add(section, fmt.Sprint("+ ", tag, " (", newOutbound["type"], ")"))
+		case newOutbound == nil:
+			d.add(section, fmt.Sprint("- ", tag, " (", oldOutbound["type"], ")"))
+		case isGroup(oldOutbound) && isGroup(newOutbound) && oldOutbound["type"] == newOutbound["type"]:
+			if change := diffGroup(oldOutbound, newOutbound); change != "" {
+				d.add(section, "~ "+tag+": "+change)
+			}
+		default:
+			if fields := changedFields(oldOutbound, newOutbound); len(fields) > 0 {
+				d.add(section, "~ "+tag+": "+strings.Join(fields, ", "))
+			}
+		}
+	}
+
+	oldRuleSets := taggedObjects(objectAt(oldConfig, "route"), "rule_set")
+	newRuleSets := taggedObjects(objectAt(newConfig, "route"), "rule_set")
+	for _, tag := range unionKeys(oldRuleSets, newRuleSets) {
+		switch {
+		case oldRuleSets[tag] == nil:
+			d.add("route.rule_set", "+ "+tag)
+		case newRuleSets[tag] == nil:
+			d.add("route.rule_set", "- "+tag)
+		default:
+			if fields := changedFields(oldRuleSets[tag], newRuleSets[tag]); len(fields) > 0 {
+				d.add("route.rule_set", "~ "+tag+": "+strings.Join(fields, ", "))
+			}
+		}
+	}
+
+	for _, section := range []string{"route", "dns"} {
+		oldSection, newSection := objectAt(oldConfig, section), objectAt(newConfig, section)
+		d.addAll(section+".rules", diffRules(oldSection["rules"], newSection["rules"]))
+		if oldFinal, newFinal := tagOrNone(oldSection["final"]), tagOrNone(newSection["final"]); oldFinal != newFinal {
+			d.add(section+".final", "~ "+oldFinal+" -> "+newFinal)
+		}
+	}
+	return d.String(), nil
+}
+
+type configDiff struct {
+	sections []string
+	lines    map[string][]string
+}
+
+func (d *configDiff) add(section string, line string) {
+	if d.lines == nil {
+		d.lines = make(map[string][]string)
+	}
+	if _, loaded := d.lines[section]; !loaded {
+		d.sections = append(d.sections, section)
+	}
+	d.lines[section] = append(d.lines[section], line)
+}
+
+func (d *configDiff) addAll(section string, lines []string) {
+	for _, line := range lines {
+		d.add(section, line)
+	}
+}
+
+func (d *configDiff) String() string {
+	var builder strings.Builder
+	for _, section := range d.sections {
+		builder.WriteString(section)
+		builder.WriteString(":\n")
+		for _, line := range d.lines[section] {
+			builder.WriteString("  ")
+			builder.WriteString(line)
+			builder.WriteString("\n")
+		}
+	}
+	return builder.String()
+}
+
+func parseDiffConfig(content []byte) (map[string]any, error) {
+	if len(bytes.TrimSpace(content)) == 0 {
+		return map[string]any{}, nil
+	}
+	content, err := io.ReadAll(json.NewCommentFilter(bytes.NewReader(content)))
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]any
+	err = json.Unmarshal(content, &config)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func objectAt(object map[string]any, key string) map[string]any {
+	value, _ := object[key].(map[string]any)
+	return value
+}
+
+func taggedObjects(object map[string]any, keys ...string) map[string]map[string]any {
+	objects := make(map[string]map[string]any)
+	for _, key := range keys {
+		for _, item := range objectList(object, key) {
+			if tag, isString := item["tag"].(string); isString {
+				objects[tag] = item
+			}
+		}
+	}
+	return objects
+}
+
+func unionKeys(a map[string]map[string]any, b map[string]map[string]any) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, loaded := a[key]; !loaded {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isGroup(outbound map[string]any) bool {
+	return outbound != nil && (outbound["type"] == C.TypeSelector || outbound["type"] == C.TypeURLTest)
+}
+
+func diffGroup(oldGroup map[string]any, newGroup map[string]any) string {
+	var changes []string
+	oldMembers, newMembers := listOf(oldGroup["outbounds"]), listOf(newGroup["outbounds"])
+	for _, member := range newMembers {
+		if !slices.Contains(oldMembers, member) {
+			changes = append(changes, "+"+member)
+		}
+	}
+	for _, member := range oldMembers {
+		if !slices.Contains(newMembers, member) {
+			changes = append(changes, "-"+member)
+		}
+	}
+	if len(changes) == 0 && !slices.Equal(oldMembers, newMembers) {
+		changes = append(changes, "order")
+	}
+	for _, field := range changedFields(oldGroup, newGroup) {
+		if field == "outbounds" {
+			continue
+		}
+		if field == "default" {
+			changes = append(changes, "default "+tagOrNone(oldGroup["default"])+" -> "+tagOrNone(newGroup["default"]))
+			continue
+		}
+		changes = append(changes, field)
+	}
+	return strings.Join(changes, " ")
+}
+
+func changedFields(oldObject map[string]any, newObject map[string]any) []string {
+	var fields []string
+	for key, value := range newObject {
+		if canonicalJSON(value) != canonicalJSON(oldObject[key]) {
+			fields = append(fields, key)
+		}
+	}
+	for key := range oldObject {
+		if _, loaded := newObject[key]; !loaded {
+			fields = append(fields, key)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// diffRules compares rules by content and position, as rules have no
+// identity and the first one matching wins. Rules outside the longest run
+// kept in order are reported as removed, added, or moved when both.
+func diffRules(oldRules any, newRules any) []string {
+	oldList, newList := canonicalList(oldRules), canonicalList(newRules)
+	// common[i][j] is the length of the longest common subsequence of
+	// oldList[i:] and newList[j:]
+	common := make([][]int, len(oldList)+1)
+	for i := range common {
+		common[i] = make([]int, len(newList)+1)
+	}
+	for i := len(oldList) - 1; i >= 0; i-- {
+		for j := len(newList) - 1; j >= 0; j-- {
+			if oldList[i] == newList[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+	var removed, added []int
+	for i, j := 0, 0; i < len(oldList) || j < len(newList); {
+		switch {
+		case i < len(oldList) && j < len(newList) && oldList[i] == newList[j]:
+			i++
+			j++
+		case j < len(newList) && (i == len(oldList) || common[i][j+1] >= common[i+1][j]):
+			added = append(added, j)
+			j++
+		default:
+			removed = append(removed, i)
+			i++
+		}
+	}
+
+	var lines []string
+	moved := make(map[int]bool)
+	for _, oldIdx := range removed {
+		addedIdx := slices.IndexFunc(added, func(newIdx int) bool {
+			return !moved[newIdx] && newList[newIdx] == oldList[oldIdx]
+		})
+		if addedIdx != -1 {
+			moved[added[addedIdx]] = true
+			lines = append(lines, fmt.Sprint("~ [", oldIdx, "] -> [", added[addedIdx], "] ", oldList[oldIdx]))
+			continue
+		}
+		lines = append(lines, fmt.Sprint("- [", oldIdx, "] ", oldList[oldIdx]))
+	}
+	for _, newIdx := range added {
+		if !moved[newIdx] {
+			lines = append(lines, fmt.Sprint("+ [", newIdx, "] ", newList[newIdx]))
+		}
+	}
+	return lines
+}
+
+func canonicalList(value any) []string {
+	list, _ := value.([]any)
+	canonical := make([]string, len(list))
+	for idx, item := range list {
+		canonical[idx] = canonicalJSON(item)
+	}
+	return canonical
+}
+
+// tagOrNone describes a reference to a tag, such as a final, which may be
+// unset.
+func tagOrNone(value any) string {
+	if tag, isString := value.(string); isString && tag != "" {
+		return tag
+	}
+	return "(none)"
+}
+
+func canonicalJSON(value any) string {
+	if value == nil {
+		return ""
+	}
+	content, _ := json.Marshal(value)
+	return string(content)
+}
@@ -0,0 +1,184 @@
+package tools_generate
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/sagernet/sing-box"
+	C "github.com/sagernet/sing-box/constant"
+	S "github.com/sagernet/sing-box/experimental/tools_generate/subscription"
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing-box/option"
+	E "github.com/sagernet/sing/common/exceptions"
+	N "github.com/sagernet/sing/common/network"
+	"github.com/sagernet/sing/service"
+)
+
+const (
+	fetchProxyDirect = "direct"
+	fetchProxyTag    = "fetch-proxy"
+)
+
+type fetchProxies struct {
+	ctx       context.Context
+	config    *Config
+	dialers   map[string]N.Dialer
+	tagger    *nodeTagger
+	instances []*box.Box
+}
+
+func newFetchProxies(ctx context.Context, config *Config, tagger *nodeTagger) *fetchProxies {
+	return &fetchProxies{
+		ctx:     ctx,
+		config:  config,
+		dialers: make(map[string]N.Dialer),
+		tagger:  tagger,
+	}
+}
+
+// Dialer returns the dialer for the fetch_proxy of subConfig, or loaded=false
+// if it names an outbound of a subscription that has not been fetched yet.
+// Outbounds are named by their final tag, as elsewhere in the config.
+func (p *fetchProxies) Dialer(subConfig subscriptionConfig, results []*S.Result) (dialer N.Dialer, loaded bool, err error) {
+	proxy := subConfig.FetchProxy
+	if proxy == "" {
+		proxy = p.config.FetchProxy
+	}
+	if proxy == "" || proxy == fetchProxyDirect {
+		return nil, true, nil
+	}
+	if dialer, loaded = p.dialers[proxy]; loaded {
+		return dialer, true, nil
+	}
+
+	var outbound option.Outbound
+	if strings.Contains(proxy, "://") {
+		outbound, err = parseFetchProxyURL(proxy)
+		if err != nil {
+			return nil, false, err
+		}
+	} else {
+		outbound, loaded, err = p.lookup(proxy, results)
+		if err != nil {
+			return nil, false, err
+		}
+		if !loaded {
+			return nil, false, nil
+		}
+	}
+	dialer, err = p.newDialer(outbound)
+	if err != nil {
+		return nil, false, err
+	}
+	p.dialers[proxy] = dialer
+	return dialer, true, nil
+}
+
+func (p *fetchProxies) lookup(tag string, results []*S.Result) (option.Outbound, bool, error) {
+	for idx, subConfig := range p.config.SubscriptionList {
+		if results[idx] == nil {
+			continue
+		}
+		nodes, err := p.tagger.tagNodes(p.ctx, idx, results[idx].Outbounds)
+		if err != nil {
+			return option.Outbound{}, false, E.Cause(err, "subscription ", subConfig.Name)
+		}
+		for _, node := range nodes {
+			if node.Tag() == tag {
+				return node.Outbound, true, nil
+			}
+		}
+	}
+	return option.Outbound{}, false, nil
+}
+
+func (p *fetchProxies) newDialer(outbound option.Outbound) (N.Dialer, error) {
+	outbound.Tag = fetchProxyTag
+	// each instance registers its services into its own registry, instead of
+	// replacing those of the process
+	instance, err := box.New(box.Options{
+		Context: include.Context(service.ContextWithRegistry(p.ctx, service.NewRegistry())),
+		Options: option.Options{
+			Log:       &option.LogOptions{Disabled: true},
+			Outbounds: []option.Outbound{outbound},
+		},
+	})
+	if err != nil {
+		return nil, E.Cause(err, "create fetch proxy")
+	}
+	err = instance.PreStart()
+	if err != nil {
+		return nil, E.Cause(err, "start fetch proxy")
+	}
+	p.instances = append(p.instances, instance)
+	dialer, loaded := instance.Outbound().Outbound(fetchProxyTag)
+	if !loaded {
+		return nil, E.New("fetch proxy outbound not found")
+	}
+	return dialer, nil
+}
+
+func (p *fetchProxies) Close() error {
+	var err error
+	for _, instance := range p.instances {
+		err = E.Errors(err, instance.Close())
+	}
+	return err
+}
+
+func parseFetchProxyURL(rawURL string) (option.Outbound, error) {
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return option.Outbound{}, err
+	}
+	var username, password string
+	if proxyURL.User != nil {
+		username = proxyURL.User.Username()
+		password, _ = proxyURL.User.Password()
+	}
+	serverOptions := option.ServerOptions{
+		Server: proxyURL.Hostname(),
+	}
+	if proxyURL.Port() != "" {
+		port, err := strconv.ParseUint(proxyURL.Port(), 10, 16)
+		if err != nil {
+			return option.Outbound{}, E.Cause(err, "parse port")
+		}
+		serverOptions.ServerPort = uint16(port)
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https":
+		options := &option.HTTPOutboundOptions{
+			ServerOptions: serverOptions,
+			Username:      username,
+			Password:      password,
+		}
+		if proxyURL.Scheme == "https" {
+			options.TLS = &option.OutboundTLSOptions{
+				Enabled:    true,
+				ServerName: proxyURL.Hostname(),
+			}
+			if options.ServerPort == 0 {
+				options.ServerPort = 443
+			}
+		} else if options.ServerPort == 0 {
+			options.ServerPort = 80
+		}
+		return option.Outbound{Type: C.TypeHTTP, Options: options}, nil
+	case "socks", "socks5", "socks5h":
+		if serverOptions.ServerPort == 0 {
+			serverOptions.ServerPort = 1080
+		}
+		return option.Outbound{Type: C.TypeSOCKS, Options: &option.SOCKSOutboundOptions{
+			ServerOptions: serverOptions,
+			Version:       "5",
+			Username:      username,
+			Password:      password,
+		}}, nil
+	default:
+		return option.Outbound{}, E.New("unsupported fetch_proxy scheme: ", proxyURL.Scheme)
+	}
+}
@@ -0,0 +1,108 @@
+package tools_generate
+
+import (
+	"context"
+	"net/netip"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/sagernet/sing-box/option"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+type outboundFilter struct {
+	include        []*regexp.Regexp
+	exclude        []*regexp.Regexp
+	types          []string
+	excludePrefix  []netip.Prefix
+	excludeDomains []string
+}
+
+func newOutboundFilter(subConfig subscriptionConfig) (*outboundFilter, error) {
+	filter := &outboundFilter{
+		types: subConfig.Types,
+	}
+	for _, expr := range subConfig.Include {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, E.Cause(err, "compile include")
+		}
+		filter.include = append(filter.include, pattern)
+	}
+	for _, expr := range subConfig.Exclude {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, E.Cause(err, "compile exclude")
+		}
+		filter.exclude = append(filter.exclude, pattern)
+	}
+	for _, server := range subConfig.ExcludeServer {
+		if prefix, err := netip.ParsePrefix(server); err == nil {
+			filter.excludePrefix = append(filter.excludePrefix, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(server); err == nil {
+			filter.excludePrefix = append(filter.excludePrefix, netip.PrefixFrom(addr, addr.BitLen()))
+		} else {
+			filter.excludeDomains = append(filter.excludeDomains, strings.ToLower(server))
+		}
+	}
+	return filter, nil
+}
+
+func (f *outboundFilter) empty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0 && len(f.types) == 0 && len(f.excludePrefix) == 0 && len(f.excludeDomains) == 0
+}
+
+func (f *outboundFilter) match(ctx context.Context, outbound option.Outbound) bool {
+	if len(f.include) > 0 && !slices.ContainsFunc(f.include, func(it *regexp.Regexp) bool {
+		return it.MatchString(outbound.Tag)
+	}) {
+		return false
+	}
+	if slices.ContainsFunc(f.exclude, func(it *regexp.Regexp) bool {
+		return it.MatchString(outbound.Tag)
+	}) {
+		return false
+	}
+	if len(f.types) > 0 && !slices.Contains(f.types, outbound.Type) {
+		return false
+	}
+	if len(f.excludePrefix) > 0 || len(f.excludeDomains) > 0 {
+		server, _ := outboundEndpoint(ctx, outbound)
+		if addr, err := netip.ParseAddr(server); err == nil {
+			if slices.ContainsFunc(f.excludePrefix, func(it netip.Prefix) bool {
+				return it.Contains(addr.Unmap())
+			}) {
+				return false
+			}
+		} else if server != "" && slices.ContainsFunc(f.excludeDomains, func(it string) bool {
+			return matchDomain(it, strings.ToLower(server))
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *outboundFilter) apply(ctx context.Context, outbounds []option.Outbound) (result []option.Outbound, filtered int) {
+	for _, outbound := range outbounds {
+		if f.match(ctx, outbound) {
+			result = append(result, outbound)
+		} else {
+			filtered++
+		}
+	}
+	return
+}
+
+// matchDomain matches domain against a wildcard pattern such as "*.example.com",
+// or otherwise against the pattern and all of its subdomains.
+func matchDomain(pattern string, domain string) bool {
+	if strings.Contains(pattern, "*") {
+		matched, _ := path.Match(pattern, domain)
+		return matched
+	}
+	pattern = strings.TrimPrefix(pattern, ".")
+	return domain == pattern || strings.HasSuffix(domain, "."+pattern)
+}
@@ -0,0 +1,280 @@
+package tools_generate
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/log"
+	"github.com/sagernet/sing-box/option"
+
+	U "github.com/sagernet/sing-box/experimental/tools_generate/utils"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json/badoption"
+)
+
+// outboundGroup is a selector or urltest outbound made by the generator.
+type outboundGroup struct {
+	Tag       string
+	Type      string
+	Outbounds []string
+	Default   string
+	URLTest   urlTestConfig
+
+	kind string
+}
+
+func (g *outboundGroup) Outbound() option.Outbound {
+	outbound := option.Outbound{
+		Type: g.Type,
+		Tag:  g.Tag,
+	}
+	switch g.Type {
+	case C.TypeURLTest:
+		outbound.Options = &option.URLTestOutboundOptions{
+			Outbounds:                 g.Outbounds,
+			URL:                       g.URLTest.URL,
+			Interval:                  badoption.Duration(g.URLTest.Interval),
+			Tolerance:                 g.URLTest.Tolerance,
+			IdleTimeout:               badoption.Duration(g.URLTest.IdleTimeout),
+			InterruptExistConnections: g.URLTest.interruptExistConnections(),
+		}
+	default:
+		outbound.Options = &option.SelectorOutboundOptions{
+			Outbounds: g.Outbounds,
+			Default:   g.Default,
+		}
+	}
+	return outbound
+}
+
+func (g *outboundGroup) MarshalJSONContext(ctx context.Context) ([]byte, error) {
+	outbound := g.Outbound()
+	return outbound.MarshalJSONContext(ctx)
+}
+
+type groupConfig struct {
+	Tag           string         `toml:"tag"`
+	Type          string         `toml:"type"`
+	Include       U.StringList   `toml:"include"`
+	Exclude       U.StringList   `toml:"exclude"`
+	Subscriptions []string       `toml:"subscriptions"`
+	Regions       []string       `toml:"regions"`
+	Types         []string       `toml:"types"`
+	Groups        []string       `toml:"groups"`
+	Outbounds     []string       `toml:"outbounds"`
+	Default       string         `toml:"default"`
+	URLTest       *urlTestConfig `toml:"urltest"`
+}
+
+type groupMatcher struct {
+	include       []*regexp.Regexp
+	exclude       []*regexp.Regexp
+	subscriptions []string
+	regions       []string
+	types         []string
+}
+
+func newGroupMatcher(groupCfg groupConfig) (*groupMatcher, error) {
+	matcher := &groupMatcher{
+		subscriptions: groupCfg.Subscriptions,
+		types:         groupCfg.Types,
+	}
+	for _, code := range groupCfg.Regions {
+		matcher.regions = append(matcher.regions, strings.ToUpper(code))
+	}
+	for _, expr := range groupCfg.Include {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, E.Cause(err, "compile include")
+		}
+		matcher.include = append(matcher.include, pattern)
+	}
+	for _, expr := range groupCfg.Exclude {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, E.Cause(err, "compile exclude")
+		}
+		matcher.exclude = append(matcher.exclude, pattern)
+	}
+	return matcher, nil
+}
+
+// empty reports whether the group selects no nodes at all, as a group that
+// only nests other groups should not pull in every node.
+func (m *groupMatcher) empty() bool {
+	return len(m.include) == 0 && len(m.subscriptions) == 0 && len(m.regions) == 0 && len(m.types) == 0
+}
+
+func (m *groupMatcher) match(subscription string, node *node) bool {
+	if len(m.subscriptions) > 0 && !slices.Contains(m.subscriptions, subscription) {
+		return false
+	}
+	if len(m.regions) > 0 && (node.Region == nil || !slices.Contains(m.regions, node.Region.Code)) {
+		return false
+	}
+	if len(m.types) > 0 && !slices.Contains(m.types, node.Outbound.Type) {
+		return false
+	}
+	matchName := func(it *regexp.Regexp) bool {
+		return it.MatchString(node.Tag()) || it.MatchString(node.Remark)
+	}
+	if len(m.include) > 0 && !slices.ContainsFunc(m.include, matchName) {
+		return false
+	}
+	return !slices.ContainsFunc(m.exclude, matchName)
+}
+
+// userGroups builds the groups of sing-box.groups. Members are the nested
+// groups first, then the listed outbounds and finally the matched nodes.
+// Groups left without members are dropped, along with references to them.
+func userGroups(config *Config, nodeList [][]*node, sharedNodeList [][]*node, groupTagByName map[string]string, usedTags []string) ([]*outboundGroup, error) {
+	groupConfigs := config.SingBox.Groups
+	userGroupTags := make(map[string]bool)
+	for _, groupCfg := range groupConfigs {
+		if groupCfg.Tag == "" {
+			return nil, E.New("missing group tag")
+		}
+		if userGroupTags[groupCfg.Tag] || slices.Contains(usedTags, groupCfg.Tag) {
+			return nil, E.New("group tag ", groupCfg.Tag, " is already used")
+		}
+		userGroupTags[groupCfg.Tag] = true
+	}
+
+	groups := make([]*outboundGroup, 0, len(groupConfigs))
+	nested := make(map[string][]string)
+	for _, groupCfg := range groupConfigs {
+		group := &outboundGroup{
+			Tag:  groupCfg.Tag,
+			Type: groupCfg.Type,
+			kind: GroupKindCustom,
+		}
+		switch group.Type {
+		case "":
+			group.Type = C.TypeSelector
+		case C.TypeSelector, C.TypeURLTest:
+		default:
+			return nil, E.New("group ", group.Tag, ": unknown type ", group.Type)
+		}
+		if group.Type == C.TypeURLTest {
+			group.URLTest = config.SingBox.URLTest.merge(groupCfg.URLTest)
+			err := group.URLTest.check()
+			if err != nil {
+				return nil, E.Cause(err, "group ", group.Tag)
+			}
+		} else if groupCfg.URLTest != nil {
+			return nil, E.New("group ", group.Tag, ": urltest is only supported by urltest groups")
+		}
+		for _, name := range groupCfg.Groups {
+			tag := name
+			if groupTag, loaded := groupTagByName[name]; loaded {
+				tag = groupTag
+			}
+			if userGroupTags[tag] {
+				nested[group.Tag] = append(nested[group.Tag], tag)
+			} else if !slices.Contains(usedTags, tag) {
+				return nil, E.New("group ", group.Tag, ": unknown group ", name)
+			}
+			group.Outbounds = append(group.Outbounds, tag)
+		}
+		group.Outbounds = append(group.Outbounds, groupCfg.Outbounds...)
+		matcher, err := newGroupMatcher(groupCfg)
+		if err != nil {
+			return nil, E.Cause(err, "group ", group.Tag)
+		}
+		if !matcher.empty() {
+			for idx, subCfg := range config.SubscriptionList {
+				for _, node := range slices.Concat(nodeList[idx], sharedNodeList[idx]) {
+					if matcher.match(subCfg.Name, node) {
+						group.Outbounds = append(group.Outbounds, node.Tag())
+					}
+				}
+			}
+		}
+		group.Outbounds = U.Unique(group.Outbounds)
+		group.Default = groupCfg.Default
+		if groupTag, loaded := groupTagByName[group.Default]; loaded {
+			group.Default = groupTag
+		}
+		groups = append(groups, group)
+	}
+
+	err := checkGroupCycle(nested)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, group := range groups {
+			if removed[group.Tag] {
+				continue
+			}
+			group.Outbounds = slices.DeleteFunc(group.Outbounds, func(tag string) bool {
+				return removed[tag]
+			})
+			if len(group.Outbounds) == 0 {
+				log.Warn("group ", group.Tag, ": no outbounds matched, skipped")
+				removed[group.Tag] = true
+				changed = true
+			}
+		}
+	}
+	groups = slices.DeleteFunc(groups, func(group *outboundGroup) bool {
+		return removed[group.Tag]
+	})
+	for _, group := range groups {
+		if group.Default == "" {
+			continue
+		}
+		if group.Type != C.TypeSelector {
+			return nil, E.New("group ", group.Tag, ": default is only supported by selector groups")
+		}
+		if !slices.Contains(group.Outbounds, group.Default) {
+			return nil, E.New("group ", group.Tag, ": default ", group.Default, " is not a member")
+		}
+	}
+	return groups, nil
+}
+
+func checkGroupCycle(nested map[string][]string) error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[string]int)
+	var visit func(tag string, path []string) error
+	visit = func(tag string, path []string) error {
+		switch state[tag] {
+		case visiting:
+			return E.New("group cycle: ", strings.Join(append(path, tag), " -> "))
+		case visited:
+			return nil
+		}
+		state[tag] = visiting
+		for _, member := range nested[tag] {
+			err := visit(member, append(path, tag))
+			if err != nil {
+				return err
+			}
+		}
+		state[tag] = visited
+		return nil
+	}
+	tags := make([]string, 0, len(nested))
+	for tag := range nested {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		err := visit(tag, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package tools_generate
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json"
+)
+
+const (
+	generateModeTemplate = "template"
+	generateModeMerge    = "merge"
+)
+
+// mergeConfig sets where generated sections are appended to the base config
+// in merge mode. Paths are dotted object keys ending at an array.
+type mergeConfig struct {
+	Outbounds       string `toml:"outbounds"`
+	RuleSet         string `toml:"rule_set"`
+	RouteRules      string `toml:"route_rules"`
+	DNSRules        string `toml:"dns_rules"`
+	Selector        string `toml:"selector"`
+	DirectDNSServer string `toml:"direct_dns_server"`
+}
+
+func (c mergeConfig) withDefaults() mergeConfig {
+	if c.Outbounds == "" {
+		c.Outbounds = "outbounds"
+	}
+	if c.RuleSet == "" {
+		c.RuleSet = "route.rule_set"
+	}
+	if c.RouteRules == "" {
+		c.RouteRules = "route.rules"
+	}
+	if c.DNSRules == "" {
+		c.DNSRules = "dns.rules"
+	}
+	if c.Selector == "" {
+		c.Selector = "proxy"
+	}
+	return c
+}
+
+func readMergeBase(path string) (map[string]any, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	content, err := io.ReadAll(json.NewCommentFilter(file))
+	if err != nil {
+		return nil, err
+	}
+	var base map[string]any
+	err = json.Unmarshal(content, &base)
+	if err != nil {
+		return nil, E.Cause(err, "parse base config")
+	}
+	if base == nil {
+		return nil, E.New("base config is not an object")
+	}
+	return base, nil
+}
+
+// mergeSingBoxConfig appends the generated outbounds, groups, rule sets and
+// rules to the base config, and passes the result through option.Options.
+func mergeSingBoxConfig(ctx context.Context, config mergeConfig, base map[string]any, data *TemplateData) ([]byte, error) {
+	config = config.withDefaults()
+
+	outboundsObject, outboundsKey, outbounds, err := mergePath(base, config.Outbounds)
+	if err != nil {
+		return nil, err
+	}
+	selectorIndex := slices.IndexFunc(outbounds, func(it any) bool {
+		outbound, isObject := it.(map[string]any)
+		return isObject && outbound["tag"] == config.Selector
+	})
+	if selectorIndex == -1 {
+		outbounds = append(outbounds, map[string]any{
+			"type":      C.TypeSelector,
+			"tag":       config.Selector,
+			"outbounds": data.OutboundTags,
+			"default":   data.DefaultOutboundTag,
+		})
+	} else {
+		selector := outbounds[selectorIndex].(map[string]any)
+		if selector["type"] != C.TypeSelector {
+			return nil, E.New("outbound ", config.Selector, " of the base config is not a selector")
+		}
+		members, _ := selector["outbounds"].([]any)
+		for _, tag := range data.OutboundTags {
+			if !slices.Contains(members, any(tag)) {
+				members = append(members, tag)
+			}
+		}
+		selector["outbounds"] = members
+		if selector["default"] == nil {
+			selector["default"] = data.DefaultOutboundTag
+		}
+	}
+	for _, group := range data.Groups {
+		outbounds, err = appendRaw(outbounds, group.JSON)
+		if err != nil {
+			return nil, E.Cause(err, "group ", group.Tag)
+		}
+	}
+	for _, node := range data.Nodes {
+		outbounds, err = appendRaw(outbounds, node.JSON)
+		if err != nil {
+			return nil, E.Cause(err, "outbound ", node.Tag)
+		}
+	}
+	outboundsObject[outboundsKey] = outbounds
+
+	var ruleSets []any
+	for _, ruleSet := range data.Rules.RuleSets {
+		ruleSets, err = appendRaw(ruleSets, ruleSet.JSON)
+		if err != nil {
+			return nil, E.Cause(err, "rule set ", ruleSet.Tag)
+		}
+	}
+	err = appendPath(base, config.RuleSet, ruleSets)
+	if err != nil {
+		return nil, err
+	}
+
+	var routeRules []any
+	if rule := mergeRule(data.Rules.Block, false); rule != nil {
+		rule["action"] = C.RuleActionTypeReject
+		routeRules = append(routeRules, rule)
+	}
+	for _, rule := range data.Rules.Route {
+		routeRules, err = appendRaw(routeRules, rule.JSON)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rule := mergeRule(data.Rules.Direct, true); rule != nil {
+		rule["outbound"] = "direct"
+		routeRules = append(routeRules, rule)
+		err = ensureDirect(base, config.Outbounds)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rule := mergeRule(data.Rules.Proxy, true); rule != nil {
+		rule["outbound"] = config.Selector
+		routeRules = append(routeRules, rule)
+	}
+	err = appendPath(base, config.RouteRules, routeRules)
+	if err != nil {
+		return nil, err
+	}
+
+	var dnsRules []any
+	for _, rule := range data.Rules.DNS {
+		dnsRules, err = appendRaw(dnsRules, rule.JSON)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.DirectDNSServer != "" {
+		if rule := mergeRule(data.Rules.Direct, false); rule != nil {
+			rule["server"] = config.DirectDNSServer
+			dnsRules = append(dnsRules, rule)
+		}
+	}
+	err = appendPath(base, config.DNSRules, dnsRules)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+	options, err := json.UnmarshalExtendedContext[option.Options](ctx, content)
+	if err != nil {
+		return nil, E.Cause(err, "merged config")
+	}
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoderContext(ctx, buffer)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(options)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// mergePath returns the object holding the array at path and the array
+// itself, creating the objects leading to it when missing.
+func mergePath(base map[string]any, path string) (map[string]any, string, []any, error) {
+	keys := strings.Split(path, ".")
+	object := base
+	for idx, key := range keys[:len(keys)-1] {
+		next, loaded := object[key]
+		if !loaded || next == nil {
+			next = make(map[string]any)
+			object[key] = next
+		}
+		nextObject, isObject := next.(map[string]any)
+		if !isObject {
+			return nil, "", nil, E.New("merge path ", path, ": ", strings.Join(keys[:idx+1], "."), " is not an object")
+		}
+		object = nextObject
+	}
+	key := keys[len(keys)-1]
+	switch value := object[key].(type) {
+	case nil:
+		return object, key, nil, nil
+	case []any:
+		return object, key, value, nil
+	default:
+		return nil, "", nil, E.New("merge path ", path, " is not an array")
+	}
+}
+
+func appendPath(base map[string]any, path string, items []any) error {
+	if len(items) == 0 {
+		return nil
+	}
+	object, key, list, err := mergePath(base, path)
+	if err != nil {
+		return err
+	}
+	object[key] = append(list, items...)
+	return nil
+}
+
+// ensureDirect adds the direct outbound used by the direct rule, unless the
+// base config has one.
+func ensureDirect(base map[string]any, path string) error {
+	object, key, outbounds, err := mergePath(base, path)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(outbounds, func(it any) bool {
+		outbound, isObject := it.(map[string]any)
+		return isObject && outbound["tag"] == "direct"
+	}) {
+		return nil
+	}
+	object[key] = append(outbounds, map[string]any{
+		"type": C.TypeDirect,
+		"tag":  "direct",
+	})
+	return nil
+}
+
+func appendRaw(list []any, content string) ([]any, error) {
+	var object any
+	err := json.Unmarshal([]byte(content), &object)
+	if err != nil {
+		return nil, err
+	}
+	return append(list, object), nil
+}
+
+func mergeRule(rule TemplateRule, withIP bool) map[string]any {
+	object := make(map[string]any)
+	if len(rule.Domain) > 0 {
+		object["domain"] = rule.Domain
+	}
+	if len(rule.DomainSuffix) > 0 {
+		object["domain_suffix"] = rule.DomainSuffix
+	}
+	if withIP && len(rule.IPCIDR) > 0 {
+		object["ip_cidr"] = rule.IPCIDR
+	}
+	if len(rule.RuleSet) > 0 {
+		object["rule_set"] = rule.RuleSet
+	}
+	if len(object) == 0 {
+		return nil
+	}
+	return object
+}
@@ -0,0 +1,93 @@
+package tools_generate
+
+import (
+	"context"
+
+	S "github.com/sagernet/sing-box/experimental/tools_generate/subscription"
+	"github.com/sagernet/sing-box/option"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json"
+)
+
+// node is an outbound of a subscription along with what the generator knows
+// about it. Outbound.Tag holds the final tag once the node has been renamed.
+type node struct {
+	Outbound option.Outbound
+	Remark   string
+	Name     string
+	Region   *region
+}
+
+func newNodes(subscriptionList []*S.Result, regions []*region) [][]*node {
+	nodeList := make([][]*node, len(subscriptionList))
+	for idx, result := range subscriptionList {
+		if result == nil {
+			continue
+		}
+		for _, outbound := range result.Outbounds {
+			nodeList[idx] = append(nodeList[idx], &node{
+				Outbound: outbound,
+				Remark:   outbound.Tag,
+				Region:   classifyRegion(regions, outbound.Tag),
+			})
+		}
+	}
+	return nodeList
+}
+
+func (n *node) Tag() string {
+	return n.Outbound.Tag
+}
+
+func outboundEndpoint(ctx context.Context, outbound option.Outbound) (server string, port uint16) {
+	content, err := outbound.MarshalJSONContext(ctx)
+	if err != nil {
+		return
+	}
+	var options struct {
+		Server     string `json:"server"`
+		ServerPort uint16 `json:"server_port"`
+	}
+	err = json.Unmarshal(content, &options)
+	if err != nil {
+		return
+	}
+	return options.Server, options.ServerPort
+}
+
+// nodeTagger applies the filter, override and rename of a subscription on
+// their own, to find its nodes by final tag before all subscriptions have
+// been fetched.
+type nodeTagger struct {
+	config    *Config
+	filters   []*outboundFilter
+	overrides []*outboundOverride
+	renamers  []*renamer
+	regions   []*region
+}
+
+// tagNodes returns the nodes of subscription idx tagged as they are within
+// that subscription, which is their final tag unless it collides with a tag
+// of another subscription. Stable tags are read but not stored.
+func (t *nodeTagger) tagNodes(ctx context.Context, idx int, outbounds []option.Outbound) ([]*node, error) {
+	outbounds, _ = t.filters[idx].apply(ctx, outbounds)
+	outbounds, err := t.overrides[idx].apply(ctx, outbounds)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*S.Result, len(t.config.SubscriptionList))
+	results[idx] = &S.Result{Outbounds: outbounds}
+	nodeList := newNodes(results, t.regions)
+	var stable *stableTags
+	if t.config.StableTags.Enabled {
+		stable, err = loadStableTags(t.config.stableTagPath())
+		if err != nil {
+			return nil, E.Cause(err, "load stable tags")
+		}
+	}
+	err = renameNodes(ctx, t.config, t.renamers, t.regions, stable, nodeList)
+	if err != nil {
+		return nil, err
+	}
+	return nodeList[idx], nil
+}
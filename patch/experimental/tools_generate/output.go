@@ -0,0 +1,125 @@
+package tools_generate
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+const (
+	backupTimeLayout = "20060102-150405.000000000"
+	// backups written before sub-second names, still pruned
+	legacyBackupTimeLayout = "20060102-150405"
+)
+
+// WriteOutput replaces path with content through a temporary file, so that
+// readers never see a partial config. The previous file is kept as a
+// timestamped backup, up to backups of them. Nothing is written when the
+// content is unchanged.
+func WriteOutput(path string, content []byte, backups int) (changed bool, err error) {
+	mode := os.FileMode(0o644)
+	current, err := os.ReadFile(path)
+	if err == nil {
+		if bytes.Equal(current, content) {
+			return false, nil
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		mode = info.Mode().Perm()
+		if backups > 0 {
+			err = backupOutput(path, current, mode, backups)
+			if err != nil {
+				return false, E.Cause(err, "backup ", path)
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return false, err
+	}
+	tempPath := file.Name()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tempPath)
+		}
+	}()
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+	err = os.Chmod(tempPath, mode)
+	if err != nil {
+		return false, err
+	}
+	err = os.Rename(tempPath, path)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func backupOutput(path string, content []byte, mode os.FileMode, backups int) error {
+	// another run may back up at the same instant, never replace its backup
+	backupTime := time.Now()
+	var file *os.File
+	for {
+		var err error
+		file, err = os.OpenFile(path+"."+backupTime.Format(backupTimeLayout)+".bak", os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		backupTime = backupTime.Add(time.Nanosecond)
+	}
+	_, err := file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	prefix := filepath.Base(path) + "."
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".bak") {
+			continue
+		}
+		if timeLength := len(name) - len(prefix) - len(".bak"); timeLength == len(backupTimeLayout) || timeLength == len(legacyBackupTimeLayout) {
+			names = append(names, name)
+		}
+	}
+	// the timestamp layouts sort lexically
+	sort.Strings(names)
+	for len(names) > backups {
+		err = os.Remove(filepath.Join(filepath.Dir(path), names[0]))
+		if err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
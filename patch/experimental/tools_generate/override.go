@@ -0,0 +1,163 @@
+package tools_generate
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/sagernet/sing-box/option"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json"
+)
+
+// outboundOverride is the override table of a subscription. Keys are merged
+// into the outbound options, except for "remove", a list of dotted paths to
+// delete, and "types", which holds overrides applied to one outbound type
+// only, after the common ones.
+type outboundOverride struct {
+	set    map[string]any
+	remove []string
+	types  map[string]*outboundOverride
+}
+
+func newOutboundOverride(raw map[string]any) (*outboundOverride, error) {
+	return parseOutboundOverride(raw, true)
+}
+
+func parseOutboundOverride(raw map[string]any, allowTypes bool) (*outboundOverride, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	override := &outboundOverride{
+		set: make(map[string]any),
+	}
+	for key, value := range raw {
+		switch key {
+		case "type", "tag":
+			return nil, E.New("override: ", key, " can not be overridden")
+		case "remove":
+			paths, ok := stringSlice(value)
+			if !ok {
+				return nil, E.New("override: remove must be a list of strings")
+			}
+			override.remove = paths
+		case "types":
+			if !allowTypes {
+				return nil, E.New("override: types can not be nested")
+			}
+			typeTables, ok := value.(map[string]any)
+			if !ok {
+				return nil, E.New("override: types must be a table")
+			}
+			override.types = make(map[string]*outboundOverride)
+			for outboundType, typeValue := range typeTables {
+				typeTable, ok := typeValue.(map[string]any)
+				if !ok {
+					return nil, E.New("override: types.", outboundType, " must be a table")
+				}
+				typeOverride, err := parseOutboundOverride(typeTable, false)
+				if err != nil {
+					return nil, E.Cause(err, "types.", outboundType)
+				}
+				if typeOverride != nil {
+					override.types[outboundType] = typeOverride
+				}
+			}
+		default:
+			override.set[key] = value
+		}
+	}
+	return override, nil
+}
+
+func stringSlice(value any) ([]string, bool) {
+	switch value := value.(type) {
+	case string:
+		return []string{value}, true
+	case []any:
+		result := make([]string, 0, len(value))
+		for _, it := range value {
+			s, ok := it.(string)
+			if !ok {
+				return nil, false
+			}
+			result = append(result, s)
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+func (o *outboundOverride) apply(ctx context.Context, outbounds []option.Outbound) ([]option.Outbound, error) {
+	if o == nil {
+		return outbounds, nil
+	}
+	result := make([]option.Outbound, 0, len(outbounds))
+	for _, outbound := range outbounds {
+		content, err := outbound.MarshalJSONContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var object map[string]any
+		err = json.Unmarshal(content, &object)
+		if err != nil {
+			return nil, err
+		}
+		o.applyObject(object)
+		if typeOverride := o.types[outbound.Type]; typeOverride != nil {
+			typeOverride.applyObject(object)
+		}
+		content, err = json.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		var overridden option.Outbound
+		err = overridden.UnmarshalJSONContext(ctx, content)
+		if err != nil {
+			return nil, E.Cause(err, "override ", outbound.Tag)
+		}
+		result = append(result, overridden)
+	}
+	return result, nil
+}
+
+func (o *outboundOverride) applyObject(object map[string]any) {
+	mergeObject(object, o.set)
+	for _, path := range o.remove {
+		removePath(object, strings.Split(path, "."))
+	}
+}
+
+// mergeObject merges tables recursively, any other value replaces the
+// existing one.
+func mergeObject(object map[string]any, values map[string]any) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := values[key]
+		if table, isTable := value.(map[string]any); isTable {
+			if existing, isObject := object[key].(map[string]any); isObject {
+				mergeObject(existing, table)
+				continue
+			}
+			copied := make(map[string]any)
+			mergeObject(copied, table)
+			object[key] = copied
+			continue
+		}
+		object[key] = value
+	}
+}
+
+func removePath(object map[string]any, path []string) {
+	if len(path) == 1 {
+		delete(object, path[0])
+		return
+	}
+	if next, isObject := object[path[0]].(map[string]any); isObject {
+		removePath(next, path[1:])
+	}
+}
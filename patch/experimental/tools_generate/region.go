@@ -0,0 +1,175 @@
+package tools_generate
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+type regionConfig struct {
+	Code     string   `toml:"code"`
+	Name     string   `toml:"name"`
+	Aliases  []string `toml:"aliases"`
+	Keywords []string `toml:"keywords"`
+}
+
+type region struct {
+	Code     string
+	Name     string
+	Aliases  []string
+	Keywords []string
+
+	flag         string
+	aliasPattern *regexp.Regexp
+}
+
+func newRegion(code string, name string, aliases []string, keywords []string) *region {
+	code = strings.ToUpper(code)
+	r := &region{
+		Code:     code,
+		Name:     name,
+		Aliases:  aliases,
+		Keywords: keywords,
+		flag:     regionFlag(code),
+	}
+	tokens := []string{regexp.QuoteMeta(code)}
+	for _, alias := range aliases {
+		tokens = append(tokens, regexp.QuoteMeta(alias))
+	}
+	// codes are short enough to appear inside words, so only match them in
+	// upper case and when not surrounded by other letters
+	r.aliasPattern = regexp.MustCompile(`(?:^|[^A-Za-z])(?:` + strings.Join(tokens, "|") + `)(?:[^A-Za-z]|$)`)
+	return r
+}
+
+func (r *region) Flag() string {
+	return r.flag
+}
+
+func (r *region) matchFlag(name string) bool {
+	return r.flag != "" && strings.Contains(name, r.flag)
+}
+
+func (r *region) matchKeyword(name string) bool {
+	lowerName := strings.ToLower(name)
+	for _, keyword := range r.Keywords {
+		if strings.Contains(lowerName, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *region) matchAlias(name string) bool {
+	return r.aliasPattern.MatchString(name)
+}
+
+// regionFlag builds the flag emoji of a two letter ISO 3166 code.
+func regionFlag(code string) string {
+	if len(code) != 2 {
+		return ""
+	}
+	var flag strings.Builder
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return ""
+		}
+		flag.WriteRune(0x1F1E6 + c - 'A')
+	}
+	return flag.String()
+}
+
+// classifyRegion looks for a flag emoji first, then for keywords and finally
+// for region codes, as codes are the most likely to give false positives.
+func classifyRegion(regions []*region, names ...string) *region {
+	for _, match := range []func(*region, string) bool{
+		(*region).matchFlag,
+		(*region).matchKeyword,
+		(*region).matchAlias,
+	} {
+		for _, name := range names {
+			for _, r := range regions {
+				if match(r, name) {
+					return r
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// loadRegions extends the builtin regions with the configured ones. Aliases
+// and keywords of a known code are added to the builtin region, unknown codes
+// are tried before the builtin regions.
+func loadRegions(configs []regionConfig) ([]*region, error) {
+	builtin := builtinRegions()
+	var custom []*region
+	for _, regionCfg := range configs {
+		code := strings.ToUpper(regionCfg.Code)
+		if code == "" {
+			return nil, E.New("missing region code")
+		}
+		regions := &custom
+		idx := slices.IndexFunc(builtin, func(it *region) bool { return it.Code == code })
+		if idx != -1 {
+			regions = &builtin
+		} else {
+			idx = slices.IndexFunc(custom, func(it *region) bool { return it.Code == code })
+		}
+		if idx == -1 {
+			name := regionCfg.Name
+			if name == "" {
+				name = code
+			}
+			custom = append(custom, newRegion(code, name, regionCfg.Aliases, regionCfg.Keywords))
+			continue
+		}
+		known := (*regions)[idx]
+		name := known.Name
+		if regionCfg.Name != "" {
+			name = regionCfg.Name
+		}
+		(*regions)[idx] = newRegion(code, name,
+			append(slices.Clone(known.Aliases), regionCfg.Aliases...),
+			append(slices.Clone(known.Keywords), regionCfg.Keywords...))
+	}
+	return append(custom, builtin...), nil
+}
+
+func builtinRegions() []*region {
+	return []*region{
+		newRegion("HK", "Hong Kong", []string{"HKG"}, []string{"香港", "Hong Kong", "HongKong"}),
+		newRegion("TW", "Taiwan", []string{"TWN"}, []string{"台湾", "臺灣", "台灣", "台北", "Taiwan", "Taipei"}),
+		newRegion("MO", "Macao", []string{"MAC"}, []string{"澳门", "澳門", "Macao", "Macau"}),
+		newRegion("JP", "Japan", []string{"JPN"}, []string{"日本", "东京", "東京", "大阪", "Japan", "Tokyo", "Osaka"}),
+		newRegion("KR", "Korea", []string{"KOR"}, []string{"韩国", "韓國", "首尔", "Korea", "Seoul"}),
+		newRegion("SG", "Singapore", []string{"SGP"}, []string{"新加坡", "狮城", "Singapore"}),
+		newRegion("US", "United States", []string{"USA"}, []string{"美国", "美國", "洛杉矶", "圣何塞", "硅谷", "西雅图", "纽约", "芝加哥", "达拉斯", "United States", "America", "Los Angeles", "San Jose", "Silicon Valley", "Seattle", "New York", "Chicago", "Dallas"}),
+		newRegion("CA", "Canada", []string{"CAN"}, []string{"加拿大", "Canada", "Toronto", "Vancouver"}),
+		newRegion("GB", "United Kingdom", []string{"UK", "GBR"}, []string{"英国", "英國", "伦敦", "United Kingdom", "Britain", "London"}),
+		newRegion("DE", "Germany", []string{"DEU"}, []string{"德国", "德國", "法兰克福", "Germany", "Frankfurt"}),
+		newRegion("FR", "France", []string{"FRA"}, []string{"法国", "法國", "巴黎", "France", "Paris"}),
+		newRegion("NL", "Netherlands", []string{"NLD"}, []string{"荷兰", "荷蘭", "阿姆斯特丹", "Netherlands", "Amsterdam"}),
+		newRegion("RU", "Russia", []string{"RUS"}, []string{"俄罗斯", "俄羅斯", "莫斯科", "Russia", "Moscow"}),
+		// before India, as the Chinese name of India is part of Indonesia's
+		newRegion("ID", "Indonesia", []string{"IDN"}, []string{"印尼", "印度尼西亚", "Indonesia", "Jakarta"}),
+		newRegion("IN", "India", []string{"IND"}, []string{"印度", "孟买", "India", "Mumbai"}),
+		newRegion("AU", "Australia", []string{"AUS"}, []string{"澳大利亚", "澳洲", "悉尼", "Australia", "Sydney"}),
+		newRegion("TR", "Turkey", []string{"TUR"}, []string{"土耳其", "Turkey", "Türkiye", "Istanbul"}),
+		newRegion("MY", "Malaysia", []string{"MYS"}, []string{"马来西亚", "馬來西亞", "Malaysia", "Kuala Lumpur"}),
+		newRegion("TH", "Thailand", []string{"THA"}, []string{"泰国", "泰國", "曼谷", "Thailand", "Bangkok"}),
+		newRegion("VN", "Vietnam", []string{"VNM"}, []string{"越南", "Vietnam"}),
+		newRegion("PH", "Philippines", []string{"PHL"}, []string{"菲律宾", "菲律賓", "Philippines", "Manila"}),
+		newRegion("AR", "Argentina", []string{"ARG"}, []string{"阿根廷", "Argentina"}),
+		newRegion("BR", "Brazil", []string{"BRA"}, []string{"巴西", "Brazil"}),
+		newRegion("IT", "Italy", []string{"ITA"}, []string{"意大利", "Italy", "Milan"}),
+		newRegion("ES", "Spain", []string{"ESP"}, []string{"西班牙", "Spain", "Madrid"}),
+		newRegion("CH", "Switzerland", []string{"CHE"}, []string{"瑞士", "Switzerland", "Zurich"}),
+		newRegion("SE", "Sweden", []string{"SWE"}, []string{"瑞典", "Sweden", "Stockholm"}),
+		newRegion("IE", "Ireland", []string{"IRL"}, []string{"爱尔兰", "Ireland", "Dublin"}),
+		newRegion("AE", "United Arab Emirates", []string{"UAE", "ARE"}, []string{"阿联酋", "迪拜", "Dubai", "Emirates"}),
+		newRegion("IL", "Israel", []string{"ISR"}, []string{"以色列", "Israel"}),
+	}
+}
@@ -0,0 +1,115 @@
+package tools_generate
+
+import (
+	"strings"
+	"text/template"
+
+	C "github.com/sagernet/sing-box/constant"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+const (
+	defaultRegionGroupTag     = "region-{{ .Code }}"
+	defaultRegionGroupAutoTag = "region-{{ .Code }}-auto"
+)
+
+type regionGroupConfig struct {
+	Enabled bool           `toml:"enabled"`
+	Tag     string         `toml:"tag"`
+	AutoTag string         `toml:"auto_tag"`
+	URLTest *urlTestConfig `toml:"urltest"`
+}
+
+type regionGroupData struct {
+	Code string
+	Name string
+	Flag string
+}
+
+// regionGroups makes a selector and an urltest group for every region with
+// nodes, across all subscriptions. The selector defaults to the urltest group.
+func regionGroups(config regionGroupConfig, urlTest urlTestConfig, regions []*region, nodeList [][]*node) ([]*outboundGroup, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	urlTest = urlTest.merge(config.URLTest)
+	err := urlTest.check()
+	if err != nil {
+		return nil, E.Cause(err, "region_groups")
+	}
+	tagTmpl, err := parseRegionGroupTag("tag", config.Tag, defaultRegionGroupTag)
+	if err != nil {
+		return nil, err
+	}
+	autoTagTmpl, err := parseRegionGroupTag("auto_tag", config.AutoTag, defaultRegionGroupAutoTag)
+	if err != nil {
+		return nil, err
+	}
+
+	regionTags := make(map[*region][]string)
+	for _, nodes := range nodeList {
+		for _, node := range nodes {
+			if node.Region != nil {
+				regionTags[node.Region] = append(regionTags[node.Region], node.Tag())
+			}
+		}
+	}
+
+	var groups []*outboundGroup
+	for _, r := range regions {
+		outboundTags := regionTags[r]
+		if len(outboundTags) == 0 {
+			continue
+		}
+		data := regionGroupData{
+			Code: r.Code,
+			Name: r.Name,
+			Flag: r.Flag(),
+		}
+		tag, err := renderRegionGroupTag(tagTmpl, data)
+		if err != nil {
+			return nil, err
+		}
+		autoTag, err := renderRegionGroupTag(autoTagTmpl, data)
+		if err != nil {
+			return nil, err
+		}
+		if tag == autoTag {
+			return nil, E.New("region group tag and auto_tag of ", r.Code, " are both ", tag)
+		}
+		groups = append(groups, &outboundGroup{
+			Tag:       tag,
+			Type:      C.TypeSelector,
+			Outbounds: append([]string{autoTag}, outboundTags...),
+			Default:   autoTag,
+			kind:      GroupKindRegion,
+		}, &outboundGroup{
+			Tag:       autoTag,
+			Type:      C.TypeURLTest,
+			Outbounds: outboundTags,
+			URLTest:   urlTest,
+			kind:      GroupKindRegionAuto,
+		})
+	}
+	return groups, nil
+}
+
+func parseRegionGroupTag(name string, text string, defaultText string) (*template.Template, error) {
+	if text == "" {
+		text = defaultText
+	}
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, E.Cause(err, "parse region_groups.", name)
+	}
+	return tmpl, nil
+}
+
+func renderRegionGroupTag(tmpl *template.Template, data regionGroupData) (string, error) {
+	var tag strings.Builder
+	err := tmpl.Execute(&tag, data)
+	if err != nil {
+		return "", E.Cause(err, "render region_groups.", tmpl.Name(), " of ", data.Code)
+	}
+	return tag.String(), nil
+}
@@ -0,0 +1,187 @@
+package tools_generate
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+type renameConfig struct {
+	Rules    []renameRuleConfig `toml:"rules"`
+	Flag     bool               `toml:"flag"`
+	Template string             `toml:"template"`
+}
+
+type renameRuleConfig struct {
+	Pattern string `toml:"pattern"`
+	Replace string `toml:"replace"`
+}
+
+type renameRule struct {
+	pattern *regexp.Regexp
+	replace string
+}
+
+type renamer struct {
+	rules    []renameRule
+	flag     bool
+	template *template.Template
+}
+
+// renameData is available to rename templates, such as
+// "{{.Sub}}-{{.Region}}-{{.Index}}".
+type renameData struct {
+	Sub         string
+	Name        string
+	Remark      string
+	Region      string
+	Flag        string
+	Type        string
+	Index       int
+	RegionIndex int
+}
+
+// newRenamer applies the global rules before the ones of the subscription,
+// and prefers the template of the subscription.
+func newRenamer(global renameConfig, subscription renameConfig) (*renamer, error) {
+	r := &renamer{
+		flag: global.Flag || subscription.Flag,
+	}
+	for _, rule := range append(append([]renameRuleConfig{}, global.Rules...), subscription.Rules...) {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, E.Cause(err, "compile rename rule")
+		}
+		r.rules = append(r.rules, renameRule{pattern, rule.Replace})
+	}
+	tagTemplate := subscription.Template
+	if tagTemplate == "" {
+		tagTemplate = global.Template
+	}
+	if tagTemplate != "" {
+		tmpl, err := template.New("rename").Parse(tagTemplate)
+		if err != nil {
+			return nil, E.Cause(err, "parse rename template")
+		}
+		r.template = tmpl
+	}
+	return r, nil
+}
+
+func newRenamers(config *Config) ([]*renamer, error) {
+	renamers := make([]*renamer, len(config.SubscriptionList))
+	for idx, subCfg := range config.SubscriptionList {
+		var err error
+		renamers[idx], err = newRenamer(config.Rename, subCfg.Rename)
+		if err != nil {
+			return nil, E.Cause(err, "subscription ", subCfg.Name)
+		}
+	}
+	return renamers, nil
+}
+
+// renameNodes sets the final tags of all nodes, which are unique across
+// subscriptions. Nodes with a stable tag are assigned first, so that new
+// nodes can not take their tags.
+func renameNodes(ctx context.Context, config *Config, renamers []*renamer, regions []*region, stable *stableTags, nodeList [][]*node) error {
+	type candidate struct {
+		node     *node
+		tag      string
+		identity string
+	}
+	var candidates []candidate
+	for idx, subCfg := range config.SubscriptionList {
+		r := renamers[idx]
+		regionIndex := make(map[string]int)
+		var identities []string
+		if stable != nil {
+			var err error
+			identities, err = stableTagIdentities(ctx, subCfg.Name, nodeList[idx])
+			if err != nil {
+				return E.Cause(err, "subscription ", subCfg.Name)
+			}
+		}
+		for index, node := range nodeList[idx] {
+			name := strings.TrimSpace(node.Remark)
+			for _, rule := range r.rules {
+				name = rule.pattern.ReplaceAllString(name, rule.replace)
+			}
+			name = strings.TrimSpace(name)
+			if nameRegion := classifyRegion(regions, name, node.Remark); nameRegion != nil {
+				node.Region = nameRegion
+			}
+			data := renameData{
+				Sub:    subCfg.Name,
+				Name:   name,
+				Remark: node.Remark,
+				Type:   node.Outbound.Type,
+				Index:  index + 1,
+			}
+			if node.Region != nil {
+				regionIndex[node.Region.Code]++
+				data.Region = node.Region.Code
+				data.Flag = node.Region.Flag()
+				data.RegionIndex = regionIndex[node.Region.Code]
+				if r.flag && data.Flag != "" && !strings.Contains(name, data.Flag) {
+					data.Name = data.Flag + " " + name
+				}
+			}
+			node.Name = data.Name
+			tag := subCfg.Name + "-" + data.Name
+			if r.template != nil {
+				var tagBuilder strings.Builder
+				err := r.template.Execute(&tagBuilder, data)
+				if err != nil {
+					return E.Cause(err, "rename ", node.Remark)
+				}
+				if rendered := strings.TrimSpace(tagBuilder.String()); rendered != "" {
+					tag = rendered
+				}
+			}
+			var identity string
+			if stable != nil {
+				identity = identities[index]
+			}
+			candidates = append(candidates, candidate{node, tag, identity})
+		}
+	}
+
+	usedTags := make(map[string]bool)
+	if stable != nil {
+		for i, it := range candidates {
+			if tag, loaded := stable.tags[it.identity]; loaded && !usedTags[tag] {
+				usedTags[tag] = true
+				it.node.Outbound.Tag = tag
+				candidates[i].node = nil
+			}
+		}
+	}
+	current := make(map[string]bool)
+	for _, it := range candidates {
+		current[it.identity] = true
+		if it.node == nil {
+			continue
+		}
+		it.node.Outbound.Tag = uniqueTag(usedTags, it.tag)
+		if stable != nil {
+			stable.Store(it.identity, it.node.Outbound.Tag)
+		}
+	}
+	if stable != nil {
+		stable.Prune(config, current)
+	}
+	return nil
+}
+
+func uniqueTag(usedTags map[string]bool, tag string) string {
+	unique := tag
+	for i := 2; usedTags[unique]; i++ {
+		unique = tag + " " + strconv.Itoa(i)
+	}
+	usedTags[unique] = true
+	return unique
+}
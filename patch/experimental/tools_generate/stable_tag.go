@@ -0,0 +1,160 @@
+package tools_generate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json"
+)
+
+type stableTagConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Path    string `toml:"path"`
+}
+
+// stableTags maps the identity of a node, which is derived from its
+// subscription, protocol and server endpoint, to the tag it was first given.
+// sing-box remembers selections by tag, so they survive providers renaming
+// their nodes.
+type stableTags struct {
+	path     string
+	tags     map[string]string
+	assigned map[string]bool
+	changed  bool
+}
+
+func (c *Config) stableTagPath() string {
+	if c.StableTags.Path != "" {
+		return c.resolvePath(c.StableTags.Path)
+	}
+	if c.CacheDir != "" {
+		return filepath.Join(c.resolvePath(c.CacheDir), "stable_tags.json")
+	}
+	return c.OutputPath() + ".tags.json"
+}
+
+func loadStableTags(path string) (*stableTags, error) {
+	stable := &stableTags{
+		path:     path,
+		tags:     make(map[string]string),
+		assigned: make(map[string]bool),
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return stable, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(content, &stable.tags)
+	if err != nil {
+		return nil, err
+	}
+	return stable, nil
+}
+
+// Store records the tag of identity, unless one has already been recorded
+// for it in this run.
+func (s *stableTags) Store(identity string, tag string) {
+	if s.assigned[identity] {
+		return
+	}
+	s.assigned[identity] = true
+	if s.tags[identity] == tag {
+		return
+	}
+	s.tags[identity] = tag
+	s.changed = true
+}
+
+// Prune drops the identities of subscriptions no longer configured, and
+// those of subscriptions with nodes in this run that no node has any more.
+// Subscriptions without nodes, such as skipped optional ones, keep theirs.
+func (s *stableTags) Prune(config *Config, current map[string]bool) {
+	var names []string
+	withNodes := make(map[string]bool)
+	for _, subCfg := range config.SubscriptionList {
+		names = append(names, subCfg.Name)
+	}
+	for identity := range current {
+		if name := identitySubscription(names, identity); name != "" {
+			withNodes[name] = true
+		}
+	}
+	for identity := range s.tags {
+		name := identitySubscription(names, identity)
+		if name == "" || withNodes[name] && !current[identity] {
+			delete(s.tags, identity)
+			s.changed = true
+		}
+	}
+}
+
+// identitySubscription returns the longest of names that identity is of.
+func identitySubscription(names []string, identity string) string {
+	var subscription string
+	for _, name := range names {
+		if len(name) > len(subscription) && strings.HasPrefix(identity, name+"/") {
+			subscription = name
+		}
+	}
+	return subscription
+}
+
+func (s *stableTags) Save() error {
+	if !s.changed {
+		return nil
+	}
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(s.tags)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(s.path), 0o755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, buffer.Bytes(), 0o644)
+}
+
+// stableTagIdentities returns the identities of the nodes of a subscription.
+// Nodes sharing a protocol and endpoint, as relays routing by path, SNI or
+// UUID do, are told apart by a hash of their options, and by their order
+// when those are equal too.
+func stableTagIdentities(ctx context.Context, subscription string, nodes []*node) ([]string, error) {
+	identities := make([]string, len(nodes))
+	endpoints := make(map[string]int)
+	for idx, node := range nodes {
+		server, port := outboundEndpoint(ctx, node.Outbound)
+		identities[idx] = subscription + "/" + node.Outbound.Type + "/" + net.JoinHostPort(server, strconv.Itoa(int(port)))
+		endpoints[identities[idx]]++
+	}
+	duplicates := make(map[string]int)
+	for idx, node := range nodes {
+		if endpoints[identities[idx]] == 1 {
+			continue
+		}
+		options, err := outboundIdentity(ctx, node.Outbound)
+		if err != nil {
+			return nil, E.Cause(err, "normalize ", node.Remark)
+		}
+		hash := sha256.Sum256([]byte(options))
+		identity := identities[idx] + "#" + hex.EncodeToString(hash[:4])
+		duplicates[identity]++
+		if duplicates[identity] > 1 {
+			identity += "#" + strconv.Itoa(duplicates[identity])
+		}
+		identities[idx] = identity
+	}
+	return identities, nil
+}
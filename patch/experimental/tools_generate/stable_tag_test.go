@@ -0,0 +1,118 @@
+package tools_generate
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing-box/option"
+
+	"github.com/stretchr/testify/require"
+)
+
+func relayNode(remark string, password string) *node {
+	return &node{
+		Remark: remark,
+		Outbound: option.Outbound{
+			Type: C.TypeShadowsocks,
+			Tag:  remark,
+			Options: &option.ShadowsocksOutboundOptions{
+				ServerOptions: option.ServerOptions{
+					Server:     "relay.example.com",
+					ServerPort: 443,
+				},
+				Method:   "aes-256-gcm",
+				Password: password,
+			},
+		},
+	}
+}
+
+func renameWithStableTags(t *testing.T, ctx context.Context, config *Config, nodes []*node) {
+	renamers, err := newRenamers(config)
+	require.NoError(t, err)
+	stable, err := loadStableTags(config.stableTagPath())
+	require.NoError(t, err)
+	nodeList := make([][]*node, len(config.SubscriptionList))
+	nodeList[0] = nodes
+	err = renameNodes(ctx, config, renamers, nil, stable, nodeList)
+	require.NoError(t, err)
+	require.NoError(t, stable.Save())
+}
+
+func TestStableTagsSharedEndpoint(t *testing.T) {
+	ctx := include.Context(context.Background())
+	config := &Config{
+		StableTags:       stableTagConfig{Enabled: true, Path: filepath.Join(t.TempDir(), "tags.json")},
+		SubscriptionList: []subscriptionConfig{{Name: "r"}},
+	}
+
+	renameWithStableTags(t, ctx, config, []*node{
+		relayNode("HK 01", "hk"),
+		relayNode("JP 01", "jp"),
+		relayNode("US 01", "us"),
+	})
+
+	// the provider renames its nodes and lists them in another order
+	nodes := []*node{
+		relayNode("US 01 new", "us"),
+		relayNode("HK 01 new", "hk"),
+		relayNode("JP 01 new", "jp"),
+	}
+	renameWithStableTags(t, ctx, config, nodes)
+	require.Equal(t, "r-US 01", nodes[0].Tag())
+	require.Equal(t, "r-HK 01", nodes[1].Tag())
+	require.Equal(t, "r-JP 01", nodes[2].Tag())
+
+	stable, err := loadStableTags(config.stableTagPath())
+	require.NoError(t, err)
+	require.Len(t, stable.tags, 3)
+}
+
+func TestStableTagsIdenticalOptions(t *testing.T) {
+	ctx := include.Context(context.Background())
+	config := &Config{
+		StableTags:       stableTagConfig{Enabled: true, Path: filepath.Join(t.TempDir(), "tags.json")},
+		SubscriptionList: []subscriptionConfig{{Name: "r"}},
+	}
+	for range 2 {
+		nodes := []*node{
+			relayNode("HK 01", "same"),
+			relayNode("JP 01", "same"),
+		}
+		renameWithStableTags(t, ctx, config, nodes)
+		require.Equal(t, "r-HK 01", nodes[0].Tag())
+		require.Equal(t, "r-JP 01", nodes[1].Tag())
+	}
+}
+
+func TestStableTagsPrune(t *testing.T) {
+	ctx := include.Context(context.Background())
+	config := &Config{
+		StableTags:       stableTagConfig{Enabled: true, Path: filepath.Join(t.TempDir(), "tags.json")},
+		SubscriptionList: []subscriptionConfig{{Name: "r"}},
+	}
+	renameWithStableTags(t, ctx, config, []*node{
+		relayNode("HK 01", "hk"),
+		relayNode("JP 01", "jp"),
+	})
+	stable, err := loadStableTags(config.stableTagPath())
+	require.NoError(t, err)
+	stable.tags["gone/shadowsocks/relay.example.com:443"] = "gone-HK 01"
+	stable.tags["skipped/shadowsocks/relay.example.com:443"] = "skipped-HK 01"
+	stable.changed = true
+	require.NoError(t, stable.Save())
+
+	// JP 01 leaves r, skipped has no nodes and gone is no longer configured
+	config.SubscriptionList = append(config.SubscriptionList, subscriptionConfig{Name: "skipped"})
+	renameWithStableTags(t, ctx, config, []*node{relayNode("HK 01", "hk")})
+
+	stable, err = loadStableTags(config.stableTagPath())
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"r/shadowsocks/relay.example.com:443":       "r-HK 01",
+		"skipped/shadowsocks/relay.example.com:443": "skipped-HK 01",
+	}, stable.tags)
+}
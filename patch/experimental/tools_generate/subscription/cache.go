@@ -0,0 +1,81 @@
+package subscription
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sagernet/sing/common/json"
+)
+
+type Cache struct {
+	dir    string
+	maxAge time.Duration
+}
+
+type CacheEntry struct {
+	URL          string      `json:"url"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"last_modified,omitempty"`
+	Header       http.Header `json:"header,omitempty"`
+	UpdatedAt    time.Time   `json:"updated_at"`
+	Content      string      `json:"content"`
+}
+
+func NewCache(dir string, maxAge time.Duration) *Cache {
+	return &Cache{dir: dir, maxAge: maxAge}
+}
+
+func (c *Cache) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(hash[:8])+".json")
+}
+
+// Load returns nil without error if nothing is cached for key.
+func (c *Cache) Load(key string) (*CacheEntry, error) {
+	content, err := os.ReadFile(c.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entry CacheEntry
+	err = json.Unmarshal(content, &entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (c *Cache) Store(key string, entry *CacheEntry) error {
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(c.dir, 0o755)
+	if err != nil {
+		return err
+	}
+	path := c.path(key)
+	tempFile, err := os.CreateTemp(c.dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Write(content)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tempFile.Name())
+		return err
+	}
+	return os.Rename(tempFile.Name(), path)
+}
+
+func (c *Cache) Fresh(entry *CacheEntry) bool {
+	return c.maxAge > 0 && time.Since(entry.UpdatedAt) < c.maxAge
+}
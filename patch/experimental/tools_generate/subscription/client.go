@@ -0,0 +1,344 @@
+package subscription
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/sagernet/sing-box/experimental/tools_generate/subscription/parser"
+	"github.com/sagernet/sing-box/log"
+	E "github.com/sagernet/sing/common/exceptions"
+	M "github.com/sagernet/sing/common/metadata"
+	N "github.com/sagernet/sing/common/network"
+)
+
+const (
+	DefaultUserAgent     = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36"
+	DefaultTimeout       = 30 * time.Second
+	DefaultRetryAttempts = 3
+	DefaultRetryBackoff  = time.Second
+	MaxRetryBackoff      = 30 * time.Second
+)
+
+type ClientOptions struct {
+	UserAgent string
+	Headers   map[string]string
+	Timeout   time.Duration
+	Insecure  bool
+	CAFile    string
+	CertFile  string
+	KeyFile   string
+	Dialer    N.Dialer
+
+	RetryAttempts int
+	RetryBackoff  time.Duration
+	MaxSize       int64
+
+	Cache    *Cache
+	CacheKey string
+	Offline  bool
+}
+
+type Client struct {
+	client    *http.Client
+	userAgent string
+	headers   map[string]string
+	cache     *Cache
+	cacheKey  string
+	offline   bool
+
+	retryAttempts int
+	retryBackoff  time.Duration
+	maxSize       int64
+}
+
+func NewClient(options ClientOptions) (*Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: options.Insecure,
+	}
+	if options.CAFile != "" {
+		caBytes, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, E.Cause(err, "read ca file")
+		}
+		certPool, err := x509.SystemCertPool()
+		if err != nil {
+			certPool = x509.NewCertPool()
+		}
+		if !certPool.AppendCertsFromPEM(caBytes) {
+			return nil, E.New("no certificates found in ", options.CAFile)
+		}
+		tlsConfig.RootCAs = certPool
+	}
+	if options.CertFile != "" || options.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, E.Cause(err, "load client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if options.Dialer != nil {
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return options.Dialer.DialContext(ctx, network, M.ParseSocksaddr(addr))
+		}
+	}
+
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	userAgent := options.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	retryAttempts := options.RetryAttempts
+	if retryAttempts <= 0 {
+		retryAttempts = DefaultRetryAttempts
+	}
+	retryBackoff := options.RetryBackoff
+	if retryBackoff <= 0 {
+		retryBackoff = DefaultRetryBackoff
+	}
+	maxSize := options.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	return &Client{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+		},
+		userAgent: userAgent,
+		headers:   options.Headers,
+		cache:     options.Cache,
+		cacheKey:  options.CacheKey,
+		offline:   options.Offline,
+
+		retryAttempts: retryAttempts,
+		retryBackoff:  retryBackoff,
+		maxSize:       maxSize,
+	}, nil
+}
+
+func (c *Client) Get(ctx context.Context, urls []string) (*Result, error) {
+	if len(urls) == 0 {
+		return nil, E.New("empty url")
+	}
+	var cached *CacheEntry
+	if c.cache != nil {
+		var err error
+		cached, err = c.cache.Load(c.cacheKey)
+		if err != nil {
+			log.Warn("load cached subscription ", c.cacheKey, ": ", err)
+		}
+		// content of a URL no longer configured is from another provider
+		if cached != nil && !slices.Contains(urls, cached.URL) {
+			cached = nil
+		}
+	}
+	if c.offline {
+		if cached == nil {
+			return nil, E.New("offline: no cached content for ", c.cacheKey)
+		}
+		return cachedResult(ctx, cached)
+	}
+	if cached != nil && c.cache.Fresh(cached) {
+		result, err := cachedResult(ctx, cached)
+		if err == nil {
+			return result, nil
+		}
+	}
+
+	var (
+		fErr     error
+		attempts int
+	)
+	for mirror, url := range urls {
+		result, err := c.fetch(ctx, url, cached, &attempts)
+		if err == nil {
+			result.URL = url
+			result.Mirror = mirror
+			result.Attempts = attempts
+			return result, nil
+		}
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+		fErr = E.Errors(fErr, E.Cause(err, url))
+	}
+	if cached != nil {
+		result, err := cachedResult(ctx, cached)
+		if err == nil {
+			log.Warn("fetch subscription ", c.cacheKey, ": ", fErr, ", using cached content from ", cached.UpdatedAt.Format(time.DateTime))
+			result.Attempts = attempts
+			return result, nil
+		}
+	}
+	return nil, fErr
+}
+
+func cachedResult(ctx context.Context, cached *CacheEntry) (*Result, error) {
+	servers, err := parser.ParseSubscription(ctx, cached.Content)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Outbounds: servers, URL: cached.URL, Cached: true}
+	result.applyHeader(cached.Header)
+	return result, nil
+}
+
+func (c *Client) fetch(ctx context.Context, url string, cached *CacheEntry, attempts *int) (*Result, error) {
+	if cached != nil && cached.URL != url {
+		cached = nil
+	}
+	var (
+		content []byte
+		header  http.Header
+		err     error
+	)
+	for attempt := 0; attempt < c.retryAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(c.backoff(attempt)):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		*attempts++
+		content, header, err = c.httpGet(ctx, url, cached)
+		if err == nil || !retryable(err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = checkContent(content)
+	if err != nil {
+		return nil, err
+	}
+	servers, err := parser.ParseSubscription(ctx, string(content))
+	if err != nil {
+		return nil, err
+	}
+	if c.cache != nil {
+		err = c.cache.Store(c.cacheKey, &CacheEntry{
+			URL:          url,
+			ETag:         header.Get("ETag"),
+			LastModified: header.Get("Last-Modified"),
+			Header:       infoHeader(header),
+			UpdatedAt:    time.Now(),
+			Content:      string(content),
+		})
+		if err != nil {
+			log.Warn("store cached subscription ", c.cacheKey, ": ", err)
+		}
+	}
+	result := &Result{Outbounds: servers}
+	result.applyHeader(header)
+	return result, nil
+}
+
+// backoff doubles the base delay for each retry and picks a random delay
+// between half and all of it.
+func (c *Client) backoff(retry int) time.Duration {
+	delay := c.retryBackoff << (retry - 1)
+	if delay <= 0 || delay > MaxRetryBackoff {
+		delay = MaxRetryBackoff
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "unexpected status: " + e.Status
+}
+
+// retryable reports whether err may go away by itself: a network failure, a
+// timeout, or a 429 or 5xx response. Anything else, such as a rejected
+// certificate or body, fails the same way when retried.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	// TLS alerts are reported as net.OpError too, as "remote error"
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op == "dial" || opErr.Op == "read" || opErr.Op == "write"
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func (c *Client) httpGet(ctx context.Context, url string, cached *CacheEntry) ([]byte, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		header := resp.Header.Clone()
+		if header.Get("ETag") == "" {
+			header.Set("ETag", cached.ETag)
+		}
+		if header.Get("Last-Modified") == "" {
+			header.Set("Last-Modified", cached.LastModified)
+		}
+		for key, values := range cached.Header {
+			if header.Get(key) == "" {
+				header[key] = values
+			}
+		}
+		return []byte(cached.Content), header, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, &StatusError{resp.StatusCode, resp.Status}
+	}
+	content, err := readBody(resp, c.maxSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, resp.Header, nil
+}
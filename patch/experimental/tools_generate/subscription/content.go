@@ -0,0 +1,78 @@
+package subscription
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	U "github.com/sagernet/sing-box/experimental/tools_generate/utils"
+	E "github.com/sagernet/sing/common/exceptions"
+
+	"github.com/andybalholm/brotli"
+)
+
+const DefaultMaxSize = 16 << 20
+
+// readBody decodes the response body and reads at most maxSize bytes of the
+// decoded content, so compressed responses cannot bypass the limit.
+func readBody(resp *http.Response, maxSize int64) ([]byte, error) {
+	if resp.ContentLength > maxSize {
+		return nil, E.New("response body too large: ", U.FormatBytes(resp.ContentLength), " > ", U.FormatBytes(maxSize))
+	}
+	var reader io.Reader
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		reader = resp.Body
+	case "gzip", "x-gzip":
+		gzipReader, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, E.Cause(err, "decode gzip")
+		}
+		defer func() {
+			_ = gzipReader.Close()
+		}()
+		reader = gzipReader
+	case "deflate":
+		zlibReader, err := zlib.NewReader(resp.Body)
+		if err != nil {
+			return nil, E.Cause(err, "decode deflate")
+		}
+		defer func() {
+			_ = zlibReader.Close()
+		}()
+		reader = zlibReader
+	case "br":
+		reader = brotli.NewReader(resp.Body)
+	default:
+		return nil, E.New("unsupported content encoding: ", encoding)
+	}
+	content, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > maxSize {
+		return nil, E.New("response body too large: exceeds ", U.FormatBytes(maxSize))
+	}
+	return content, nil
+}
+
+var htmlTitleRegexp = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// checkContent rejects bodies which can not be a subscription, such as the
+// login or captive portal pages returned in place of one.
+func checkContent(content []byte) error {
+	if len(bytes.TrimSpace(content)) == 0 {
+		return E.New("empty response body")
+	}
+	if strings.HasPrefix(http.DetectContentType(content), "text/html") {
+		if match := htmlTitleRegexp.FindSubmatch(content); match != nil {
+			return E.New("received an HTML page instead of a subscription: ", strings.TrimSpace(string(match[1])))
+		}
+		return E.New("received an HTML page instead of a subscription")
+	}
+	return nil
+}
@@ -18,6 +18,17 @@ import (
 	"github.com/metacubex/mihomo/constant"
 )
 
+func init() {
+	Register(NewParser("clash", DetectClashSubscription, ParseClashSubscription))
+}
+
+func DetectClashSubscription(content string) Confidence {
+	if strings.HasPrefix(content, "proxies:") || strings.Contains(content, "\nproxies:") {
+		return ConfidenceHigh
+	}
+	return ConfidenceLow
+}
+
 func ParseClashSubscription(_ context.Context, content string) ([]option.Outbound, error) {
 	config, err := config.UnmarshalRawConfig([]byte(content))
 	if err != nil {
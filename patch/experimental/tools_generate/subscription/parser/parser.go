@@ -2,26 +2,116 @@ package parser
 
 import (
 	"context"
+	"slices"
+	"sync"
 
 	"github.com/sagernet/sing-box/option"
 	E "github.com/sagernet/sing/common/exceptions"
 )
 
-var subscriptionParsers = []func(ctx context.Context, content string) ([]option.Outbound, error){
-	ParseBoxSubscription,
-	ParseClashSubscription,
-	ParseSIP008Subscription,
-	ParseRawSubscription,
+type Confidence int
+
+const (
+	ConfidenceNone Confidence = iota
+	ConfidenceLow
+	ConfidenceMedium
+	ConfidenceHigh
+)
+
+type Parser interface {
+	Name() string
+	Detect(content string) Confidence
+	Parse(ctx context.Context, content string) ([]option.Outbound, error)
+}
+
+type (
+	DetectFunc func(content string) Confidence
+	ParseFunc  func(ctx context.Context, content string) ([]option.Outbound, error)
+)
+
+func NewParser(name string, detect DetectFunc, parse ParseFunc) Parser {
+	return &funcParser{name, detect, parse}
+}
+
+type funcParser struct {
+	name   string
+	detect DetectFunc
+	parse  ParseFunc
+}
+
+func (p *funcParser) Name() string {
+	return p.name
+}
+
+func (p *funcParser) Detect(content string) Confidence {
+	return p.detect(content)
+}
+
+func (p *funcParser) Parse(ctx context.Context, content string) ([]option.Outbound, error) {
+	return p.parse(ctx, content)
+}
+
+var (
+	parserAccess        sync.RWMutex
+	subscriptionParsers []Parser
+)
+
+// Register makes a parser available to ParseSubscription. It is meant to be
+// called from init, so that builds can contribute formats behind build tags.
+func Register(parser Parser) {
+	parserAccess.Lock()
+	defer parserAccess.Unlock()
+	for _, registered := range subscriptionParsers {
+		if registered.Name() == parser.Name() {
+			panic("parser: duplicate registration of " + parser.Name())
+		}
+	}
+	subscriptionParsers = append(subscriptionParsers, parser)
+}
+
+func Parsers() []Parser {
+	parserAccess.RLock()
+	defer parserAccess.RUnlock()
+	return slices.Clone(subscriptionParsers)
+}
+
+// Detect returns the parsers able to handle content, ordered by confidence.
+// Parsers with equal confidence keep their registration order.
+func Detect(content string) []Parser {
+	type candidate struct {
+		parser     Parser
+		confidence Confidence
+	}
+	var candidates []candidate
+	for _, parser := range Parsers() {
+		confidence := parser.Detect(content)
+		if confidence > ConfidenceNone {
+			candidates = append(candidates, candidate{parser, confidence})
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		return int(b.confidence - a.confidence)
+	})
+	parsers := make([]Parser, 0, len(candidates))
+	for _, it := range candidates {
+		parsers = append(parsers, it.parser)
+	}
+	return parsers
 }
 
 func ParseSubscription(ctx context.Context, content string) ([]option.Outbound, error) {
 	var pErr error
-	for _, parser := range subscriptionParsers {
-		servers, err := parser(ctx, content)
+	for _, parser := range Detect(content) {
+		servers, err := parser.Parse(ctx, content)
 		if len(servers) > 0 {
 			return servers, nil
 		}
-		pErr = E.Errors(pErr, err)
+		if err != nil {
+			pErr = E.Errors(pErr, E.Cause(err, parser.Name()))
+		}
+	}
+	if pErr == nil {
+		return nil, E.New("no servers found")
 	}
 	return nil, E.Cause(pErr, "no servers found")
 }
@@ -9,6 +9,17 @@ import (
 	E "github.com/sagernet/sing/common/exceptions"
 )
 
+func init() {
+	Register(NewParser("raw", DetectRawSubscription, ParseRawSubscription))
+}
+
+func DetectRawSubscription(content string) Confidence {
+	if strings.Contains(content, "://") {
+		return ConfidenceMedium
+	}
+	return ConfidenceLow
+}
+
 func ParseRawSubscription(_ context.Context, content string) ([]option.Outbound, error) {
 	if base64Content, err := decodeBase64URLSafe(content); err == nil {
 		servers, _ := parseRawSubscription(base64Content)
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"context"
+	"strings"
 
 	C "github.com/sagernet/sing-box/constant"
 	"github.com/sagernet/sing-box/option"
@@ -10,6 +11,20 @@ import (
 	"github.com/sagernet/sing/common/json"
 )
 
+func init() {
+	Register(NewParser("sing-box", DetectBoxSubscription, ParseBoxSubscription))
+}
+
+func DetectBoxSubscription(content string) Confidence {
+	if !strings.HasPrefix(strings.TrimSpace(content), "{") {
+		return ConfidenceNone
+	}
+	if strings.Contains(content, `"outbounds"`) {
+		return ConfidenceHigh
+	}
+	return ConfidenceLow
+}
+
 func ParseBoxSubscription(ctx context.Context, content string) ([]option.Outbound, error) {
 	options, err := json.UnmarshalExtendedContext[option.Options](ctx, []byte(content))
 	if err != nil {
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"context"
+	"strings"
 
 	C "github.com/sagernet/sing-box/constant"
 	"github.com/sagernet/sing-box/option"
@@ -25,6 +26,20 @@ type ShadowsocksServerDocument struct {
 	PluginOpts string `json:"plugin_opts"`
 }
 
+func init() {
+	Register(NewParser("sip008", DetectSIP008Subscription, ParseSIP008Subscription))
+}
+
+func DetectSIP008Subscription(content string) Confidence {
+	if !strings.HasPrefix(strings.TrimSpace(content), "{") {
+		return ConfidenceNone
+	}
+	if strings.Contains(content, `"servers"`) {
+		return ConfidenceHigh
+	}
+	return ConfidenceLow
+}
+
 func ParseSIP008Subscription(_ context.Context, content string) ([]option.Outbound, error) {
 	var document ShadowsocksDocument
 	err := json.Unmarshal([]byte(content), &document)
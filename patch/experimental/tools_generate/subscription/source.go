@@ -0,0 +1,125 @@
+package subscription
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sagernet/sing-box/experimental/tools_generate/subscription/parser"
+	"github.com/sagernet/sing-box/log"
+	"github.com/sagernet/sing-box/option"
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+const DefaultCommandTimeout = time.Minute
+
+// GetPath parses the files matching pattern. Directories contribute their
+// regular files except dotfiles, and files of a directory that hold no
+// servers, such as a README, are skipped as long as another one does.
+func GetPath(ctx context.Context, pattern string) ([]option.Outbound, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	inDir := make(map[string]bool)
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			paths = append(paths, match)
+			continue
+		}
+		entries, err := os.ReadDir(match)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+				path := filepath.Join(match, entry.Name())
+				paths = append(paths, path)
+				inDir[path] = true
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return nil, E.New("no files found: ", pattern)
+	}
+
+	var (
+		outbounds []option.Outbound
+		skipErr   error
+	)
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		servers, err := parser.ParseSubscription(ctx, string(content))
+		if err != nil {
+			if !inDir[path] {
+				return nil, E.Cause(err, "parse ", path)
+			}
+			skipErr = E.Errors(skipErr, E.Cause(err, "parse ", path))
+			continue
+		}
+		outbounds = append(outbounds, servers...)
+	}
+	if len(outbounds) == 0 && skipErr != nil {
+		return nil, skipErr
+	}
+	if skipErr != nil {
+		log.Debug("skip files without servers: ", skipErr)
+	}
+	return outbounds, nil
+}
+
+type CommandOptions struct {
+	Args    []string
+	Dir     string
+	Env     map[string]string
+	Timeout time.Duration
+}
+
+func GetCommand(ctx context.Context, options CommandOptions) ([]option.Outbound, error) {
+	if len(options.Args) == 0 {
+		return nil, E.New("empty command")
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCommandTimeout
+	}
+	commandCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	command := exec.CommandContext(commandCtx, options.Args[0], options.Args[1:]...)
+	command.Dir = options.Dir
+	if len(options.Env) > 0 {
+		keys := make([]string, 0, len(options.Env))
+		for key := range options.Env {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		command.Env = os.Environ()
+		for _, key := range keys {
+			command.Env = append(command.Env, key+"="+options.Env[key])
+		}
+	}
+	stderr := &bytes.Buffer{}
+	command.Stderr = stderr
+	output, err := command.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, E.Cause(err, "run ", options.Args[0], ": ", message)
+		}
+		return nil, E.Cause(err, "run ", options.Args[0])
+	}
+	return parser.ParseSubscription(ctx, string(output))
+}
@@ -2,38 +2,39 @@ package subscription
 
 import (
 	"context"
-	"io"
-	"net/http"
 	"strings"
+	"time"
 
 	"github.com/sagernet/sing-box/experimental/tools_generate/subscription/parser"
 	"github.com/sagernet/sing-box/option"
 )
 
+type Result struct {
+	Outbounds []option.Outbound
+
+	// URL is the mirror that served the content, Mirror its index and
+	// Attempts the number of requests made across all mirrors.
+	URL      string
+	Mirror   int
+	Attempts int
+	Cached   bool
+
+	UserInfo       *UserInfo
+	UpdateInterval time.Duration
+}
+
 func Get(ctx context.Context, urlOrContent string) (subscriptions []option.Outbound, err error) {
 	if strings.HasPrefix(urlOrContent, "http") {
-		contentBytes, err := httpGet(ctx, urlOrContent)
+		client, err := NewClient(ClientOptions{})
 		if err != nil {
 			return nil, err
 		}
-		return parser.ParseSubscription(ctx, string(contentBytes))
+		result, err := client.Get(ctx, []string{urlOrContent})
+		if err != nil {
+			return nil, err
+		}
+		return result.Outbounds, nil
 	}
 
 	return parser.ParseSubscription(ctx, urlOrContent)
 }
-
-func httpGet(ctx context.Context, url string) ([]byte, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-	return io.ReadAll(resp.Body)
-}
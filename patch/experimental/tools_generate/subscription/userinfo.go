@@ -0,0 +1,116 @@
+package subscription
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	U "github.com/sagernet/sing-box/experimental/tools_generate/utils"
+)
+
+const (
+	headerUserInfo       = "Subscription-Userinfo"
+	headerUpdateInterval = "Profile-Update-Interval"
+)
+
+type UserInfo struct {
+	Upload   int64
+	Download int64
+	Total    int64
+	Expire   time.Time
+}
+
+// ParseUserInfo parses a subscription-userinfo header such as
+// "upload=0; download=1024; total=10240; expire=1767196800".
+func ParseUserInfo(header string) *UserInfo {
+	var info UserInfo
+	var found bool
+	for _, field := range strings.Split(header, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "upload":
+			info.Upload = int64(number)
+		case "download":
+			info.Download = int64(number)
+		case "total":
+			info.Total = int64(number)
+		case "expire":
+			if number > 0 {
+				info.Expire = time.Unix(int64(number), 0)
+			}
+		default:
+			continue
+		}
+		found = true
+	}
+	if !found {
+		return nil
+	}
+	return &info
+}
+
+func (u *UserInfo) Used() int64 {
+	return u.Upload + u.Download
+}
+
+func (u *UserInfo) Remaining() int64 {
+	return max(u.Total-u.Used(), 0)
+}
+
+func (u *UserInfo) Left() string {
+	return U.FormatBytes(u.Remaining())
+}
+
+func (u *UserInfo) ExpireDate() string {
+	if u.Expire.IsZero() {
+		return ""
+	}
+	return u.Expire.Format(time.DateOnly)
+}
+
+func (u *UserInfo) String() string {
+	var parts []string
+	if u.Total > 0 {
+		parts = append(parts, u.Left()+" left")
+	}
+	if !u.Expire.IsZero() {
+		parts = append(parts, "exp "+u.ExpireDate())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (r *Result) applyHeader(header http.Header) {
+	if header == nil {
+		return
+	}
+	if value := header.Get(headerUserInfo); value != "" {
+		r.UserInfo = ParseUserInfo(value)
+	}
+	if value := header.Get(headerUpdateInterval); value != "" {
+		hours, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err == nil && hours > 0 {
+			r.UpdateInterval = time.Duration(hours * float64(time.Hour))
+		}
+	}
+}
+
+func infoHeader(header http.Header) http.Header {
+	result := make(http.Header)
+	for _, key := range []string{headerUserInfo, headerUpdateInterval} {
+		if value := header.Get(key); value != "" {
+			result.Set(key, value)
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
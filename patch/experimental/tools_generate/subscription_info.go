@@ -0,0 +1,68 @@
+package tools_generate
+
+import (
+	"strings"
+	"text/template"
+	"time"
+
+	S "github.com/sagernet/sing-box/experimental/tools_generate/subscription"
+	U "github.com/sagernet/sing-box/experimental/tools_generate/utils"
+	"github.com/sagernet/sing-box/log"
+)
+
+const (
+	defaultQuotaWarning  = U.Size(1 << 30)
+	defaultExpireWarning = 72 * time.Hour
+)
+
+func checkSubscriptionUsage(config *Config, results []*S.Result) {
+	quotaWarning := config.QuotaWarning
+	if quotaWarning == 0 {
+		quotaWarning = defaultQuotaWarning
+	}
+	expireWarning := config.ExpireWarning
+	if expireWarning == 0 {
+		expireWarning = defaultExpireWarning
+	}
+	for idx, result := range results {
+		if result == nil || result.UserInfo == nil {
+			continue
+		}
+		name := config.SubscriptionList[idx].Name
+		info := result.UserInfo
+		if info.Total > 0 && info.Remaining() < int64(quotaWarning) {
+			log.Warn("subscription ", name, ": ", info.Left(), " of ", U.FormatBytes(info.Total), " left")
+		}
+		if !info.Expire.IsZero() {
+			if remaining := time.Until(info.Expire); remaining <= 0 {
+				log.Warn("subscription ", name, ": expired on ", info.ExpireDate())
+			} else if remaining < expireWarning {
+				log.Warn("subscription ", name, ": expires on ", info.ExpireDate())
+			}
+		}
+	}
+}
+
+type groupTagData struct {
+	Name           string
+	UserInfo       *S.UserInfo
+	UpdateInterval time.Duration
+}
+
+// subscriptionGroupTag renders the tag of the group of a subscription, which
+// is "out-<name>" unless sing-box.group_tag is set.
+func subscriptionGroupTag(tmpl *template.Template, subCfg subscriptionConfig, result *S.Result) (string, error) {
+	if tmpl == nil {
+		return "out-" + subCfg.Name, nil
+	}
+	var tag strings.Builder
+	err := tmpl.Execute(&tag, groupTagData{
+		Name:           subCfg.Name,
+		UserInfo:       result.UserInfo,
+		UpdateInterval: result.UpdateInterval,
+	})
+	if err != nil {
+		return "", err
+	}
+	return tag.String(), nil
+}
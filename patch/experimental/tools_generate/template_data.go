@@ -0,0 +1,265 @@
+package tools_generate
+
+import (
+	"context"
+	"time"
+
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/option"
+
+	S "github.com/sagernet/sing-box/experimental/tools_generate/subscription"
+	"github.com/sagernet/sing/common/json"
+)
+
+// TemplateDataVersion is increased whenever a field of TemplateData changes
+// in an incompatible way. Fields are only ever added within a version.
+const TemplateDataVersion = 1
+
+// TemplateData is what the sing-box template is executed with.
+type TemplateData struct {
+	Version int
+
+	Subscriptions []TemplateSubscription
+	// Nodes are the outbounds of all subscriptions, after filtering,
+	// deduplication and renaming.
+	Nodes []TemplateNode
+	// Groups are all selector and urltest outbounds made by the generator,
+	// including the subscription groups and out-proxy-auto.
+	Groups []TemplateGroup
+	Rules  TemplateRules
+
+	Gateway   string
+	ClashPort int
+
+	// The fields below predate Version 1 and are kept for existing templates.
+
+	DNSRules   []string
+	RouteRules []string
+	RuleSet    []string
+
+	OutboundTags       []string
+	DefaultOutboundTag string
+	OutboundGroups     []map[string]any
+	Outbounds          []string
+	AutoOutbounds      []string
+	AutoOutbound       string
+	URLTest            URLTestData
+
+	DirectDomains []string
+	ProxyDomains  []string
+	BlockDomains  []string
+
+	DirectDomainSuffixes []string
+	ProxyDomainSuffixes  []string
+	BlockDomainSuffixes  []string
+
+	DirectIPs []string
+	ProxyIPs  []string
+
+	DirectRuleSet []string
+	ProxyRuleSet  []string
+	BlockRuleSet  []string
+}
+
+type TemplateSubscription struct {
+	Name           string
+	URL            []string
+	GroupTag       string
+	DefaultTag     string
+	OutboundTags   []string
+	UserInfo       *S.UserInfo
+	UpdateInterval time.Duration
+	Cached         bool
+}
+
+type TemplateNode struct {
+	Tag          string
+	Type         string
+	Remark       string
+	Name         string
+	Subscription string
+	Server       string
+	ServerPort   uint16
+	Detour       string
+	Region       string
+	RegionName   string
+	Flag         string
+	Outbound     option.Outbound
+	JSON         string
+}
+
+func (n TemplateNode) String() string {
+	return n.JSON
+}
+
+const (
+	GroupKindSubscription     = "subscription"
+	GroupKindSubscriptionAuto = "subscription-auto"
+	GroupKindRegion           = "region"
+	GroupKindRegionAuto       = "region-auto"
+	GroupKindCustom           = "custom"
+	GroupKindAuto             = "auto"
+)
+
+type TemplateGroup struct {
+	Tag       string
+	Type      string
+	Kind      string
+	Outbounds []string
+	Default   string
+	URLTest   URLTestData
+	JSON      string
+}
+
+func (g TemplateGroup) String() string {
+	return g.JSON
+}
+
+type TemplateRules struct {
+	Direct   TemplateRule
+	Proxy    TemplateRule
+	Block    TemplateRule
+	DNS      []TemplateDNSRule
+	Route    []TemplateRouteRule
+	RuleSets []TemplateRuleSet
+}
+
+type TemplateRule struct {
+	Domain       []string
+	DomainSuffix []string
+	IPCIDR       []string
+	RuleSet      []string
+}
+
+type TemplateDNSRule struct {
+	Server       string
+	Domain       []string
+	DomainSuffix []string
+	RuleSet      []string
+	JSON         string
+}
+
+type TemplateRouteRule struct {
+	Action       string
+	Outbound     string
+	Domain       []string
+	DomainSuffix []string
+	IPCIDR       []string
+	RuleSet      []string
+	JSON         string
+}
+
+type TemplateRuleSet struct {
+	Tag            string
+	URL            string
+	DownloadDetour string
+	JSON           string
+}
+
+func newTemplateNode(ctx context.Context, subscription string, node *node) (TemplateNode, error) {
+	content, err := node.Outbound.MarshalJSONContext(ctx)
+	if err != nil {
+		return TemplateNode{}, err
+	}
+	var options struct {
+		Server     string `json:"server"`
+		ServerPort uint16 `json:"server_port"`
+		Detour     string `json:"detour"`
+	}
+	_ = json.Unmarshal(content, &options)
+	templateNode := TemplateNode{
+		Tag:          node.Tag(),
+		Type:         node.Outbound.Type,
+		Remark:       node.Remark,
+		Name:         node.Name,
+		Subscription: subscription,
+		Server:       options.Server,
+		ServerPort:   options.ServerPort,
+		Detour:       options.Detour,
+		Outbound:     node.Outbound,
+		JSON:         string(content),
+	}
+	if node.Region != nil {
+		templateNode.Region = node.Region.Code
+		templateNode.RegionName = node.Region.Name
+		templateNode.Flag = node.Region.Flag()
+	}
+	return templateNode, nil
+}
+
+func newTemplateGroup(ctx context.Context, group *outboundGroup) (TemplateGroup, error) {
+	content, err := group.MarshalJSONContext(ctx)
+	if err != nil {
+		return TemplateGroup{}, err
+	}
+	templateGroup := TemplateGroup{
+		Tag:       group.Tag,
+		Type:      group.Type,
+		Kind:      group.kind,
+		Outbounds: group.Outbounds,
+		Default:   group.Default,
+		JSON:      string(content),
+	}
+	if group.Type == C.TypeURLTest {
+		templateGroup.URLTest = group.URLTest.data()
+	}
+	return templateGroup, nil
+}
+
+func newTemplateRules(config singBoxConfig, directDomains []string) TemplateRules {
+	rules := TemplateRules{
+		Direct: TemplateRule{
+			Domain:       directDomains,
+			DomainSuffix: config.DirectRule.DomainSuffix,
+			IPCIDR:       config.DirectRule.IPCIDR,
+			RuleSet:      config.DirectRule.RuleSet,
+		},
+		Proxy: TemplateRule{
+			Domain:       config.ProxyRule.Domain,
+			DomainSuffix: config.ProxyRule.DomainSuffix,
+			IPCIDR:       config.ProxyRule.IPCIDR,
+			RuleSet:      config.ProxyRule.RuleSet,
+		},
+		Block: TemplateRule{
+			Domain:       config.BlockRule.Domain,
+			DomainSuffix: config.BlockRule.DomainSuffix,
+			RuleSet:      config.BlockRule.RuleSet,
+		},
+	}
+	for _, rule := range config.DNSRuleList {
+		content, _ := rule.MarshalJSON()
+		rules.DNS = append(rules.DNS, TemplateDNSRule{
+			Server:       rule.Server,
+			Domain:       rule.Domain,
+			DomainSuffix: rule.DomainSuffix,
+			RuleSet:      rule.RuleSet,
+			JSON:         string(content),
+		})
+	}
+	for _, rule := range config.RouteRuleList {
+		content, _ := rule.MarshalJSON()
+		action := rule.Action
+		if action == "" {
+			action = "route"
+		}
+		rules.Route = append(rules.Route, TemplateRouteRule{
+			Action:       action,
+			Outbound:     rule.Outbound,
+			Domain:       rule.Domain,
+			DomainSuffix: rule.DomainSuffix,
+			IPCIDR:       rule.IPCIDR,
+			RuleSet:      rule.RuleSet,
+			JSON:         string(content),
+		})
+	}
+	for _, ruleSet := range config.RuleSetList {
+		content, _ := ruleSet.MarshalJSON()
+		rules.RuleSets = append(rules.RuleSets, TemplateRuleSet{
+			Tag:            ruleSet.Tag,
+			URL:            ruleSet.Url,
+			DownloadDetour: ruleSet.DownloadDetour,
+			JSON:           string(content),
+		})
+	}
+	return rules
+}
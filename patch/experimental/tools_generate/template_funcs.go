@@ -0,0 +1,240 @@
+package tools_generate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+
+	U "github.com/sagernet/sing-box/experimental/tools_generate/utils"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json"
+)
+
+// templateFuncs returns the functions available to the sing-box template.
+// Functions taking a list take it last, so that they can be piped:
+//
+//	{{ .Nodes | filterRegion "HK" "JP" | tags | toJSON }}
+func templateFuncs(ctx context.Context, config *Config, tmpl *template.Template) template.FuncMap {
+	return template.FuncMap{
+		"MarshalArray":  U.MarshalArrayF,
+		"ConcatStrings": U.Concat[string],
+
+		"toJSON": func(value any) (string, error) {
+			content, err := json.MarshalContext(ctx, value)
+			return string(content), err
+		},
+		"toPrettyJSON": func(value any) (string, error) {
+			return indentJSON(ctx, value, "", "  ")
+		},
+		"indentJSON": func(prefix string, indent string, value any) (string, error) {
+			return indentJSON(ctx, value, prefix, indent)
+		},
+		"jsonArray": jsonArray,
+		"join": func(sep string, list any) (string, error) {
+			items, err := toList(list)
+			if err != nil {
+				return "", err
+			}
+			values := make([]string, 0, len(items))
+			for _, item := range items {
+				values = append(values, fmt.Sprint(item))
+			}
+			return strings.Join(values, sep), nil
+		},
+		"quote": func(value any) string {
+			return U.MarshalArrayF(fmt.Sprint(value))
+		},
+
+		"filterType": func(args ...any) ([]TemplateNode, error) {
+			return filterNodes(args, func(types []string, node TemplateNode) bool {
+				return slices.Contains(types, node.Type)
+			})
+		},
+		"filterRegion": func(args ...any) ([]TemplateNode, error) {
+			return filterNodes(args, func(codes []string, node TemplateNode) bool {
+				return slices.ContainsFunc(codes, func(code string) bool {
+					return strings.EqualFold(code, node.Region)
+				})
+			})
+		},
+		"filterSubscription": func(args ...any) ([]TemplateNode, error) {
+			return filterNodes(args, func(names []string, node TemplateNode) bool {
+				return slices.Contains(names, node.Subscription)
+			})
+		},
+		"filterName": func(expr string, nodes []TemplateNode) ([]TemplateNode, error) {
+			return matchNodes(expr, nodes, true)
+		},
+		"excludeName": func(expr string, nodes []TemplateNode) ([]TemplateNode, error) {
+			return matchNodes(expr, nodes, false)
+		},
+		"tags": func(list any) ([]string, error) {
+			items, err := toList(list)
+			if err != nil {
+				return nil, err
+			}
+			tags := make([]string, 0, len(items))
+			for _, item := range items {
+				switch item := item.(type) {
+				case TemplateNode:
+					tags = append(tags, item.Tag)
+				case TemplateGroup:
+					tags = append(tags, item.Tag)
+				case string:
+					tags = append(tags, item)
+				default:
+					return nil, E.New("tags: unsupported item ", reflect.TypeOf(item))
+				}
+			}
+			return tags, nil
+		},
+
+		"default": func(defaultValue any, value any) any {
+			if value == nil || reflect.ValueOf(value).IsZero() {
+				return defaultValue
+			}
+			if v := reflect.ValueOf(value); (v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0 {
+				return defaultValue
+			}
+			return value
+		},
+		"env": os.Getenv,
+		"readFile": func(path string) (string, error) {
+			content, err := os.ReadFile(config.resolvePath(path))
+			return string(content), err
+		},
+		"include": func(name string, data any) (string, error) {
+			buffer := &bytes.Buffer{}
+			err := tmpl.ExecuteTemplate(buffer, name, data)
+			return buffer.String(), err
+		},
+		"dict": func(pairs ...any) (map[string]any, error) {
+			if len(pairs)%2 != 0 {
+				return nil, E.New("dict: odd number of arguments")
+			}
+			dict := make(map[string]any, len(pairs)/2)
+			for i := 0; i < len(pairs); i += 2 {
+				key, isString := pairs[i].(string)
+				if !isString {
+					return nil, E.New("dict: key ", pairs[i], " is not a string")
+				}
+				dict[key] = pairs[i+1]
+			}
+			return dict, nil
+		},
+		"list": func(items ...any) []any {
+			return items
+		},
+	}
+}
+
+func indentJSON(ctx context.Context, value any, prefix string, indent string) (string, error) {
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoderContext(ctx, buffer)
+	encoder.SetIndent(prefix, indent)
+	err := encoder.Encode(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buffer.String(), "\n"), nil
+}
+
+// jsonArray joins already encoded JSON values, such as .Outbounds or .Nodes,
+// into an array. Lists are flattened and empty values skipped, so optional
+// entries can be passed without producing stray commas.
+func jsonArray(values ...any) (string, error) {
+	var elements []string
+	var appendValue func(value any) error
+	appendValue = func(value any) error {
+		switch value := value.(type) {
+		case nil:
+		case string:
+			if strings.TrimSpace(value) != "" {
+				elements = append(elements, value)
+			}
+		case fmt.Stringer:
+			return appendValue(value.String())
+		default:
+			items, err := toList(value)
+			if err != nil {
+				return E.Cause(err, "jsonArray")
+			}
+			for _, item := range items {
+				err = appendValue(item)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, value := range values {
+		err := appendValue(value)
+		if err != nil {
+			return "", err
+		}
+	}
+	return "[" + strings.Join(elements, ", ") + "]", nil
+}
+
+func toList(list any) ([]any, error) {
+	value := reflect.ValueOf(list)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return nil, E.New("not a list: ", reflect.TypeOf(list))
+	}
+	items := make([]any, 0, value.Len())
+	for i := 0; i < value.Len(); i++ {
+		items = append(items, value.Index(i).Interface())
+	}
+	return items, nil
+}
+
+// filterNodes splits args into the string arguments and the trailing node
+// list, and keeps the nodes accepted by match.
+func filterNodes(args []any, match func(values []string, node TemplateNode) bool) ([]TemplateNode, error) {
+	if len(args) < 2 {
+		return nil, E.New("missing arguments")
+	}
+	nodes, isNodes := args[len(args)-1].([]TemplateNode)
+	if !isNodes {
+		return nil, E.New("last argument is not a list of nodes")
+	}
+	var values []string
+	for _, arg := range args[:len(args)-1] {
+		switch arg := arg.(type) {
+		case string:
+			values = append(values, arg)
+		case []string:
+			values = append(values, arg...)
+		default:
+			return nil, E.New("unsupported argument ", arg)
+		}
+	}
+	var result []TemplateNode
+	for _, node := range nodes {
+		if match(values, node) {
+			result = append(result, node)
+		}
+	}
+	return result, nil
+}
+
+func matchNodes(expr string, nodes []TemplateNode, include bool) ([]TemplateNode, error) {
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	var result []TemplateNode
+	for _, node := range nodes {
+		if (pattern.MatchString(node.Tag) || pattern.MatchString(node.Remark)) == include {
+			result = append(result, node)
+		}
+	}
+	return result, nil
+}
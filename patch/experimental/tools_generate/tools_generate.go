@@ -7,23 +7,42 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
 	"slices"
 	"sort"
 	"sync"
 	"text/template"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	C "github.com/sagernet/sing-box/constant"
+	"github.com/sagernet/sing-box/log"
 	"github.com/sagernet/sing-box/option"
 
 	S "github.com/sagernet/sing-box/experimental/tools_generate/subscription"
 	U "github.com/sagernet/sing-box/experimental/tools_generate/utils"
+	E "github.com/sagernet/sing/common/exceptions"
 	"github.com/sagernet/sing/common/json"
+	N "github.com/sagernet/sing/common/network"
 )
 
 type Config struct {
+	FetchProxy       string               `toml:"fetch_proxy"`
+	CacheDir         string               `toml:"cache_dir"`
+	CacheMaxAge      time.Duration        `toml:"cache_max_age"`
+	Offline          bool                 `toml:"offline"`
+	QuotaWarning     U.Size               `toml:"quota_warning"`
+	ExpireWarning    time.Duration        `toml:"expire_warning"`
+	MaxSize          U.Size               `toml:"max_size"`
+	Rename           renameConfig         `toml:"rename"`
+	Dedup            string               `toml:"dedup"`
+	StableTags       stableTagConfig      `toml:"stable_tags"`
+	Regions          []regionConfig       `toml:"regions"`
 	SubscriptionList []subscriptionConfig `toml:"subscriptions"`
 	SingBox          singBoxConfig        `toml:"sing-box"`
+
+	dir string
 }
 
 func Parse(configBytes []byte) (*Config, error) {
@@ -35,22 +54,112 @@ func Parse(configBytes []byte) (*Config, error) {
 	return config, nil
 }
 
+func ParseFile(configPath string) (*Config, error) {
+	configBytes, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	config, err := Parse(configBytes)
+	if err != nil {
+		return nil, err
+	}
+	config.dir = filepath.Dir(configPath)
+	return config, nil
+}
+
+// resolvePath makes path relative to the directory of the config file, as
+// are all paths of the config, including the template and the output.
+func (c *Config) resolvePath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(c.dir, path)
+}
+
+func (c *Config) OutputPath() string {
+	return c.resolvePath(c.SingBox.Output)
+}
+
 type subscriptionConfig struct {
-	Name            string `toml:"name"`
-	URL             string `toml:"url"`
-	Content         string `toml:"content"`
-	DefaultOutbound string `toml:"default"`
+	Name            string            `toml:"name"`
+	URL             U.StringList      `toml:"url"`
+	Content         string            `toml:"content"`
+	Path            string            `toml:"path"`
+	Command         []string          `toml:"command"`
+	CommandTimeout  time.Duration     `toml:"command_timeout"`
+	CommandEnv      map[string]string `toml:"command_env"`
+	DefaultOutbound string            `toml:"default"`
+	Optional        bool              `toml:"optional"`
+	Priority        int               `toml:"priority"`
+	FetchProxy      string            `toml:"fetch_proxy"`
+	RetryAttempts   int               `toml:"retry_attempts"`
+	RetryBackoff    time.Duration     `toml:"retry_backoff"`
+	MaxSize         U.Size            `toml:"max_size"`
+
+	Include       U.StringList `toml:"include"`
+	Exclude       U.StringList `toml:"exclude"`
+	Types         []string     `toml:"types"`
+	ExcludeServer []string     `toml:"exclude_server"`
+
+	Rename   renameConfig   `toml:"rename"`
+	Override map[string]any `toml:"override"`
+	Via      string         `toml:"via"`
+
+	AutoGroup bool           `toml:"auto_group"`
+	URLTest   *urlTestConfig `toml:"urltest"`
+
+	UserAgent string            `toml:"user_agent"`
+	Headers   map[string]string `toml:"headers"`
+	Timeout   time.Duration     `toml:"timeout"`
+	Insecure  bool              `toml:"insecure"`
+	CAFile    string            `toml:"ca_file"`
+	CertFile  string            `toml:"cert_file"`
+	KeyFile   string            `toml:"key_file"`
+}
+
+func (c *Config) clientOptions(subConfig subscriptionConfig) S.ClientOptions {
+	options := S.ClientOptions{
+		UserAgent: subConfig.UserAgent,
+		Headers:   subConfig.Headers,
+		Timeout:   subConfig.Timeout,
+		Insecure:  subConfig.Insecure,
+		CAFile:    c.resolvePath(subConfig.CAFile),
+		CertFile:  c.resolvePath(subConfig.CertFile),
+		KeyFile:   c.resolvePath(subConfig.KeyFile),
+		CacheKey:  subConfig.Name,
+		Offline:   c.Offline,
+
+		RetryAttempts: subConfig.RetryAttempts,
+		RetryBackoff:  subConfig.RetryBackoff,
+		MaxSize:       int64(subConfig.MaxSize),
+	}
+	if options.MaxSize == 0 {
+		options.MaxSize = int64(c.MaxSize)
+	}
+	if c.CacheDir != "" {
+		options.Cache = S.NewCache(c.resolvePath(c.CacheDir), c.CacheMaxAge)
+	}
+	return options
 }
 
 type singBoxConfig struct {
+	Mode             string   `toml:"mode"`
 	Template         string   `toml:"template"`
+	Includes         []string `toml:"includes"`
 	Output           string   `toml:"output"`
+	Backups          int      `toml:"backups"`
 	Gateway          string   `toml:"gateway"`
 	ClashPort        int      `toml:"clash_port"`
 	DefaultOutbound  string   `toml:"default"`
+	GroupTag         string   `toml:"group_tag"`
 	AutoOutboundList []string `toml:"auto_outbounds"`
 	IncludeServer    bool     `toml:"include_server"`
 
+	Merge        mergeConfig       `toml:"merge"`
+	URLTest      urlTestConfig     `toml:"urltest"`
+	RegionGroups regionGroupConfig `toml:"region_groups"`
+	Groups       []groupConfig     `toml:"groups"`
+
 	RuleSetList   []singBoxRuleSetConfig       `toml:"rule_set"`
 	DirectRule    singBoxRouteRuleDirectConfig `toml:"direct_rule"`
 	ProxyRule     singBoxRouteRuleProxyConfig  `toml:"proxy_rule"`
@@ -97,25 +206,154 @@ type singBoxRouteRuleConfig struct {
 	Outbound string `toml:"outbound"`
 }
 
-func GenerateSingBoxConfig(config *Config) ([]byte, error) {
-	tmplBytes, err := os.ReadFile(config.SingBox.Template)
+// Generated is a generated config along with the stable tags assigned to its
+// outbounds, which are to be saved once the config has been written.
+type Generated struct {
+	Content    []byte
+	stableTags *stableTags
+}
+
+func (g *Generated) SaveStableTags() error {
+	if g.stableTags == nil {
+		return nil
+	}
+	return g.stableTags.Save()
+}
+
+func GenerateSingBoxConfig(ctx context.Context, config *Config) (*Generated, error) {
+	var (
+		tmpl        *template.Template
+		tmplContent string
+		mergeBase   map[string]any
+		err         error
+	)
+	switch config.SingBox.Mode {
+	case "", generateModeTemplate:
+		tmplContent = defaultTemplate
+		if config.SingBox.Template != "" {
+			tmplBytes, err := os.ReadFile(config.resolvePath(config.SingBox.Template))
+			if err != nil {
+				return nil, err
+			}
+			tmplContent = string(tmplBytes)
+		}
+		tmpl = template.New("sing-box")
+		tmpl, err = tmpl.Funcs(templateFuncs(ctx, config, tmpl)).Parse(tmplContent)
+		if err != nil {
+			return nil, err
+		}
+		for _, pattern := range config.SingBox.Includes {
+			tmpl, err = tmpl.ParseGlob(config.resolvePath(pattern))
+			if err != nil {
+				return nil, E.Cause(err, "parse includes")
+			}
+		}
+	case generateModeMerge:
+		if config.SingBox.Template == "" {
+			return nil, E.New("merge mode requires a base config as template")
+		}
+		mergeBase, err = readMergeBase(config.resolvePath(config.SingBox.Template))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, E.New("unknown mode: ", config.SingBox.Mode)
+	}
+
+	var groupTagTmpl *template.Template
+	if config.SingBox.GroupTag != "" {
+		groupTagTmpl, err = template.New("group_tag").Parse(config.SingBox.GroupTag)
+		if err != nil {
+			return nil, E.Cause(err, "parse group_tag")
+		}
+	}
+
+	filters := make([]*outboundFilter, len(config.SubscriptionList))
+	overrides := make([]*outboundOverride, len(config.SubscriptionList))
+	for idx, subCfg := range config.SubscriptionList {
+		filters[idx], err = newOutboundFilter(subCfg)
+		if err != nil {
+			return nil, E.Cause(err, "subscription ", subCfg.Name)
+		}
+		overrides[idx], err = newOutboundOverride(subCfg.Override)
+		if err != nil {
+			return nil, E.Cause(err, "subscription ", subCfg.Name)
+		}
+	}
+	renamers, err := newRenamers(config)
+	if err != nil {
+		return nil, err
+	}
+	err = checkDedupPolicy(config.Dedup)
+	if err != nil {
+		return nil, err
+	}
+	regions, err := loadRegions(config.Regions)
 	if err != nil {
 		return nil, err
 	}
+	err = config.SingBox.URLTest.check()
+	if err != nil {
+		return nil, E.Cause(err, "sing-box")
+	}
+	subscriptionURLTests := make([]urlTestConfig, len(config.SubscriptionList))
+	for idx, subCfg := range config.SubscriptionList {
+		if subCfg.URLTest != nil && !subCfg.AutoGroup {
+			return nil, E.New("subscription ", subCfg.Name, ": urltest requires auto_group")
+		}
+		subscriptionURLTests[idx] = config.SingBox.URLTest.merge(subCfg.URLTest)
+		err = subscriptionURLTests[idx].check()
+		if err != nil {
+			return nil, E.Cause(err, "subscription ", subCfg.Name)
+		}
+	}
 
-	tmpl, err := template.New("sing-box").
-		Funcs(template.FuncMap{
-			"MarshalArray":  U.MarshalArrayF,
-			"ConcatStrings": U.Concat[string],
-		}).
-		Parse(string(tmplBytes))
+	tagger := &nodeTagger{
+		config:    config,
+		filters:   filters,
+		overrides: overrides,
+		renamers:  renamers,
+		regions:   regions,
+	}
+	subscriptionList, err := getSubscriptions(ctx, config, tagger)
 	if err != nil {
 		return nil, err
 	}
+	checkSubscriptionUsage(config, subscriptionList)
 
-	ctx := context.Background()
+	for idx, result := range subscriptionList {
+		if result == nil {
+			continue
+		}
+		name := config.SubscriptionList[idx].Name
+		if !filters[idx].empty() {
+			var filtered int
+			result.Outbounds, filtered = filters[idx].apply(ctx, result.Outbounds)
+			if len(result.Outbounds) == 0 {
+				log.Warn("subscription ", name, ": all ", filtered, " outbounds filtered out")
+			} else if filtered > 0 {
+				log.Info("subscription ", name, ": filtered out ", filtered, " outbounds")
+			}
+		}
+		result.Outbounds, err = overrides[idx].apply(ctx, result.Outbounds)
+		if err != nil {
+			return nil, E.Cause(err, "subscription ", name)
+		}
+	}
 
-	subscriptionList, err := getSubscriptions(ctx, config)
+	nodeList := newNodes(subscriptionList, regions)
+	sharedNodeList, err := dedupNodes(ctx, config, nodeList)
+	if err != nil {
+		return nil, err
+	}
+	var stable *stableTags
+	if config.StableTags.Enabled {
+		stable, err = loadStableTags(config.stableTagPath())
+		if err != nil {
+			return nil, E.Cause(err, "load stable tags")
+		}
+	}
+	err = renameNodes(ctx, config, renamers, regions, stable, nodeList)
 	if err != nil {
 		return nil, err
 	}
@@ -125,37 +363,58 @@ func GenerateSingBoxConfig(config *Config) ([]byte, error) {
 	var outboundDomains []string
 	var outboundGroups []map[string]any
 	var outboundGroupTags []string
+	var groups []*outboundGroup
+	var subscriptionGroups []*outboundGroup
+	var templateSubscriptions []TemplateSubscription
+	groupTagByName := make(map[string]string)
 
 	for idx, subCfg := range config.SubscriptionList {
 		var subOutboundTags []string
-		subscriptions := subscriptionList[idx]
-		for _, subscription := range subscriptions {
-			subscription.Tag = subCfg.Name + "-" + subscription.Tag
-			outbound, err := subscription.MarshalJSONContext(ctx)
-			if err != nil {
-				return nil, err
+		defaultSubscriptionOutboundTag := ""
+		for _, node := range nodeList[idx] {
+			subOutboundTags = append(subOutboundTags, node.Tag())
+			if subCfg.DefaultOutbound != "" && defaultSubscriptionOutboundTag == "" &&
+				(node.Remark == subCfg.DefaultOutbound || node.Tag() == subCfg.DefaultOutbound || node.Tag() == subCfg.Name+"-"+subCfg.DefaultOutbound) {
+				defaultSubscriptionOutboundTag = node.Tag()
 			}
-			outbounds = append(outbounds, string(outbound))
-			subOutboundTags = append(subOutboundTags, subscription.Tag)
 			if config.SingBox.IncludeServer {
-				var options struct {
-					Server string `json:"server"`
-				}
-				err := json.Unmarshal(outbound, &options)
-				if err != nil {
-					continue
+				if server, _ := outboundEndpoint(ctx, node.Outbound); server != "" {
+					outboundDomains = append(outboundDomains, server)
 				}
-				outboundDomains = append(outboundDomains, options.Server)
 			}
 		}
+		for _, node := range sharedNodeList[idx] {
+			subOutboundTags = append(subOutboundTags, node.Tag())
+		}
+		if len(subOutboundTags) == 0 {
+			continue
+		}
 		sort.Strings(subOutboundTags)
 		outboundTags = append(outboundTags, subOutboundTags...)
 
-		subscriptionOutboundGroupTag := "out-" + subCfg.Name
+		subscriptionOutboundGroupTag, err := subscriptionGroupTag(groupTagTmpl, subCfg, subscriptionList[idx])
+		if err != nil {
+			return nil, E.Cause(err, "render group_tag of ", subCfg.Name)
+		}
 		outboundGroupTags = append(outboundGroupTags, subscriptionOutboundGroupTag)
-
-		defaultSubscriptionOutboundTag := subCfg.Name + "-" + subCfg.DefaultOutbound
-		if !slices.Contains(subOutboundTags, defaultSubscriptionOutboundTag) {
+		groupTagByName["out-"+subCfg.Name] = subscriptionOutboundGroupTag
+
+		if subCfg.AutoGroup {
+			autoTag := subscriptionOutboundGroupTag + "-auto"
+			groups = append(groups, &outboundGroup{
+				Tag:       autoTag,
+				Type:      C.TypeURLTest,
+				Outbounds: subOutboundTags,
+				URLTest:   subscriptionURLTests[idx],
+				kind:      GroupKindSubscriptionAuto,
+			})
+			groupTagByName["out-"+subCfg.Name+"-auto"] = autoTag
+			subOutboundTags = append([]string{autoTag}, subOutboundTags...)
+			if defaultSubscriptionOutboundTag == "" {
+				defaultSubscriptionOutboundTag = autoTag
+			}
+		}
+		if defaultSubscriptionOutboundTag == "" {
 			defaultSubscriptionOutboundTag = subOutboundTags[0]
 		}
 
@@ -163,10 +422,91 @@ func GenerateSingBoxConfig(config *Config) ([]byte, error) {
 			"Tag":                subscriptionOutboundGroupTag,
 			"DefaultOutboundTag": defaultSubscriptionOutboundTag,
 			"OutboundTags":       subOutboundTags,
+			"Name":               subCfg.Name,
+			"UserInfo":           subscriptionList[idx].UserInfo,
+			"UpdateInterval":     subscriptionList[idx].UpdateInterval,
 		})
+		subscriptionGroups = append(subscriptionGroups, &outboundGroup{
+			Tag:       subscriptionOutboundGroupTag,
+			Type:      C.TypeSelector,
+			Outbounds: subOutboundTags,
+			Default:   defaultSubscriptionOutboundTag,
+			kind:      GroupKindSubscription,
+		})
+		templateSubscriptions = append(templateSubscriptions, TemplateSubscription{
+			Name:           subCfg.Name,
+			URL:            subCfg.URL,
+			GroupTag:       subscriptionOutboundGroupTag,
+			DefaultTag:     defaultSubscriptionOutboundTag,
+			OutboundTags:   subOutboundTags,
+			UserInfo:       subscriptionList[idx].UserInfo,
+			UpdateInterval: subscriptionList[idx].UpdateInterval,
+			Cached:         subscriptionList[idx].Cached,
+		})
+	}
+
+	regionGroupList, err := regionGroups(config.SingBox.RegionGroups, config.SingBox.URLTest, regions, nodeList)
+	if err != nil {
+		return nil, err
+	}
+	groups = append(groups, regionGroupList...)
+	usedTags := U.Unique(outboundTags, outboundGroupTags)
+	for _, group := range groups {
+		if slices.Contains(usedTags, group.Tag) {
+			return nil, E.New("group tag ", group.Tag, " is already used")
+		}
+		usedTags = append(usedTags, group.Tag)
+	}
+	customGroups, err := userGroups(config, nodeList, sharedNodeList, groupTagByName, usedTags)
+	if err != nil {
+		return nil, err
+	}
+	// generated urltest groups are reached through their selector, while
+	// every user defined group is offered
+	customGroupIndex := len(groups)
+	groups = append(groups, customGroups...)
+
+	groupMembers := make(map[string][]string)
+	for _, group := range slices.Concat(subscriptionGroups, groups) {
+		groupMembers[group.Tag] = group.Outbounds
+	}
+	err = applyVia(ctx, config, nodeList, groupMembers, groupTagByName)
+	if err != nil {
+		return nil, err
+	}
+	var templateNodes []TemplateNode
+	for idx, nodes := range nodeList {
+		for _, node := range nodes {
+			templateNode, err := newTemplateNode(ctx, config.SubscriptionList[idx].Name, node)
+			if err != nil {
+				return nil, err
+			}
+			templateNodes = append(templateNodes, templateNode)
+			outbounds = append(outbounds, templateNode.JSON)
+		}
+	}
+	var templateGroups []TemplateGroup
+	for _, group := range subscriptionGroups {
+		templateGroup, err := newTemplateGroup(ctx, group)
+		if err != nil {
+			return nil, err
+		}
+		templateGroups = append(templateGroups, templateGroup)
+	}
+	for idx, group := range groups {
+		templateGroup, err := newTemplateGroup(ctx, group)
+		if err != nil {
+			return nil, err
+		}
+		templateGroups = append(templateGroups, templateGroup)
+		outbounds = append(outbounds, templateGroup.JSON)
+		if group.Type == C.TypeSelector || idx >= customGroupIndex {
+			outboundGroupTags = append(outboundGroupTags, group.Tag)
+		}
 	}
 
 	var autoOutbounds []string
+	var autoOutbound string
 	{
 		for _, tag := range config.SingBox.AutoOutboundList {
 			if U.Contains(outboundTags, tag) {
@@ -176,72 +516,70 @@ func GenerateSingBoxConfig(config *Config) ([]byte, error) {
 		autoOutbounds = U.Unique(autoOutbounds)
 		if len(autoOutbounds) > 1 {
 			outboundGroupTags = append([]string{"out-proxy-auto"}, outboundGroupTags...)
+			group := &outboundGroup{
+				Tag:       "out-proxy-auto",
+				Type:      C.TypeURLTest,
+				Outbounds: autoOutbounds,
+				URLTest:   config.SingBox.URLTest,
+				kind:      GroupKindAuto,
+			}
+			templateGroup, err := newTemplateGroup(ctx, group)
+			if err != nil {
+				return nil, err
+			}
+			templateGroups = append([]TemplateGroup{templateGroup}, templateGroups...)
+			autoOutbound = templateGroup.JSON
 		}
 	}
 
-	tmplBuffer := &bytes.Buffer{}
-	err = tmpl.Execute(tmplBuffer, struct {
-		DNSRules   []string
-		RouteRules []string
-		RuleSet    []string
+	if len(outboundGroupTags) == 0 {
+		return nil, E.New("no outbounds available from subscriptions")
+	}
 
-		Gateway   string
-		ClashPort int
+	var subscriptionDomains []string
+	for _, subscription := range config.SubscriptionList {
+		for _, subscriptionURL := range subscription.URL {
+			u, err := url.Parse(subscriptionURL)
+			if err == nil {
+				subscriptionDomains = append(subscriptionDomains, u.Hostname())
+			}
+		}
+	}
+	directDomains := U.Unique(config.SingBox.DirectRule.Domain, subscriptionDomains, outboundDomains)
 
-		OutboundTags       []string
-		DefaultOutboundTag string
-		OutboundGroups     []map[string]any
-		Outbounds          []string
-		AutoOutbounds      []string
+	defaultOutboundTag := config.SingBox.DefaultOutbound
+	if tag, loaded := groupTagByName[defaultOutboundTag]; loaded {
+		defaultOutboundTag = tag
+	} else if !slices.Contains(outboundGroupTags, defaultOutboundTag) {
+		defaultOutboundTag = outboundGroupTags[0]
+	}
 
-		DirectDomains []string
-		ProxyDomains  []string
-		BlockDomains  []string
+	data := &TemplateData{
+		Version: TemplateDataVersion,
 
-		DirectDomainSuffixes []string
-		ProxyDomainSuffixes  []string
-		BlockDomainSuffixes  []string
+		Subscriptions: templateSubscriptions,
+		Nodes:         templateNodes,
+		Groups:        templateGroups,
+		Rules:         newTemplateRules(config.SingBox, directDomains),
 
-		DirectIPs []string
-		ProxyIPs  []string
+		Gateway:   config.SingBox.Gateway,
+		ClashPort: config.SingBox.ClashPort,
 
-		DirectRuleSet []string
-		ProxyRuleSet  []string
-		BlockRuleSet  []string
-	}{
 		DNSRules:   marshal(config.SingBox.DNSRuleList),
 		RouteRules: marshal(config.SingBox.RouteRuleList),
 		RuleSet:    marshal(config.SingBox.RuleSetList),
 
-		Gateway:   config.SingBox.Gateway,
-		ClashPort: config.SingBox.ClashPort,
+		OutboundTags:       outboundGroupTags,
+		DefaultOutboundTag: defaultOutboundTag,
+		OutboundGroups:     outboundGroups,
+		Outbounds:          outbounds,
+		AutoOutbounds:      autoOutbounds,
+		AutoOutbound:       autoOutbound,
+		URLTest:            config.SingBox.URLTest.data(),
 
-		OutboundTags: outboundGroupTags,
-		DefaultOutboundTag: func() string {
-			if !slices.Contains(outboundGroupTags, config.SingBox.DefaultOutbound) {
-				return outboundGroupTags[0]
-			}
-			return config.SingBox.DefaultOutbound
-		}(),
-		OutboundGroups: outboundGroups,
-		Outbounds:      outbounds,
-		AutoOutbounds:  autoOutbounds,
-
-		DirectDomains: func() []string {
-			var subscriptionDomains []string
-			for _, subscription := range config.SubscriptionList {
-				if subscription.URL == "" {
-					continue
-				}
-				u, err := url.Parse(subscription.URL)
-				if err == nil {
-					subscriptionDomains = append(subscriptionDomains, u.Hostname())
-				}
-			}
-			return U.Unique(config.SingBox.DirectRule.Domain, subscriptionDomains, outboundDomains)
-		}(),
-		ProxyDomains: config.SingBox.ProxyRule.Domain,
-		BlockDomains: config.SingBox.BlockRule.Domain,
+		DirectDomains: directDomains,
+		ProxyDomains:  config.SingBox.ProxyRule.Domain,
+		BlockDomains:  config.SingBox.BlockRule.Domain,
 
 		DirectDomainSuffixes: config.SingBox.DirectRule.DomainSuffix,
 		ProxyDomainSuffixes:  config.SingBox.ProxyRule.DomainSuffix,
@@ -253,49 +591,155 @@ func GenerateSingBoxConfig(config *Config) ([]byte, error) {
 		DirectRuleSet: config.SingBox.DirectRule.RuleSet,
 		ProxyRuleSet:  config.SingBox.ProxyRule.RuleSet,
 		BlockRuleSet:  config.SingBox.BlockRule.RuleSet,
-	})
-	if err != nil {
-		return nil, err
 	}
 
-	return tmplBuffer.Bytes(), nil
+	var content []byte
+	if mergeBase != nil {
+		content, err = mergeSingBoxConfig(ctx, config.SingBox.Merge, mergeBase, data)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		tmplBuffer := &bytes.Buffer{}
+		err = tmpl.Execute(tmplBuffer, data)
+		if err != nil {
+			return nil, err
+		}
+		content = tmplBuffer.Bytes()
+	}
+	err = validateConfig(ctx, content, tmplContent)
+	if err != nil {
+		return nil, E.Cause(err, "invalid config")
+	}
+	return &Generated{Content: content, stableTags: stable}, nil
 }
 
-func getSubscriptions(ctx context.Context, config *Config) (outboundList [][]option.Outbound, err error) {
-	ctx, cancel := context.WithCancel(ctx)
+func getSubscriptions(ctx context.Context, config *Config, tagger *nodeTagger) (results []*S.Result, err error) {
+	if config.Offline && config.CacheDir == "" {
+		return nil, E.New("offline mode requires cache_dir")
+	}
+
+	fetchCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	outboundList = make([][]option.Outbound, len(config.SubscriptionList))
-	wg := sync.WaitGroup{}
-	wg.Add(len(config.SubscriptionList))
-	for idx, subConfig := range config.SubscriptionList {
-		go func() {
-			defer wg.Done()
-
-			var outbounds []option.Outbound
-			var sErr error
-			if subConfig.URL != "" {
-				outbounds, sErr = S.Get(ctx, subConfig.URL)
-			} else if subConfig.Content != "" {
-				outbounds, sErr = S.Get(ctx, subConfig.Content)
-			} else {
-				sErr = errors.New("empty url and content")
-			}
-			if sErr == nil && len(outbounds) == 0 {
-				sErr = fmt.Errorf("empty outbounds %v", subConfig)
+	proxies := newFetchProxies(fetchCtx, config, tagger)
+	defer proxies.Close()
+
+	results = make([]*S.Result, len(config.SubscriptionList))
+	errs := make([]error, len(config.SubscriptionList))
+	pending := make([]int, len(config.SubscriptionList))
+	for idx := range pending {
+		pending[idx] = idx
+	}
+	// subscriptions fetched through an outbound of another subscription
+	// wait until the subscription providing it has been fetched
+	for len(pending) > 0 && fetchCtx.Err() == nil {
+		var ready, waiting []int
+		dialers := make(map[int]N.Dialer)
+		for _, idx := range pending {
+			dialer, loaded, dErr := proxies.Dialer(config.SubscriptionList[idx], results)
+			if dErr != nil {
+				errs[idx] = E.Cause(dErr, "fetch_proxy")
+				continue
 			}
-			if sErr == nil {
-				outboundList[idx] = outbounds
-				return
+			if !loaded {
+				waiting = append(waiting, idx)
+				continue
 			}
-			if sErr != nil && !errors.Is(sErr, context.Canceled) {
-				err = sErr
-				cancel()
+			dialers[idx] = dialer
+			ready = append(ready, idx)
+		}
+		if len(ready) == 0 {
+			for _, idx := range waiting {
+				errs[idx] = E.New("fetch_proxy outbound not found")
 			}
-		}()
+			break
+		}
+
+		wg := sync.WaitGroup{}
+		wg.Add(len(ready))
+		for _, idx := range ready {
+			subConfig := config.SubscriptionList[idx]
+			dialer := dialers[idx]
+			go func() {
+				defer wg.Done()
+
+				result, sErr := getSubscription(fetchCtx, config, subConfig, dialer)
+				if sErr == nil && len(result.Outbounds) == 0 {
+					sErr = E.New("empty outbounds")
+				}
+				if sErr == nil {
+					if result.Cached {
+						if result.Attempts > 0 {
+							log.Warn("subscription ", subConfig.Name, " unreachable after ", result.Attempts, " attempts, fell back to cached content")
+						}
+					} else if result.Mirror > 0 || result.Attempts > 1 {
+						log.Warn("subscription ", subConfig.Name, " fetched from ", result.URL, " after ", result.Attempts, " attempts")
+					}
+					results[idx] = result
+					return
+				}
+				errs[idx] = sErr
+				if !subConfig.Optional {
+					cancel()
+				}
+			}()
+		}
+		wg.Wait()
+		pending = waiting
 	}
-	wg.Wait()
-	return
+
+	for idx, sErr := range errs {
+		if sErr == nil || errors.Is(sErr, context.Canceled) {
+			continue
+		}
+		subConfig := config.SubscriptionList[idx]
+		if subConfig.Optional {
+			log.Warn("skip optional subscription ", subConfig.Name, ": ", sErr)
+			continue
+		}
+		err = E.Errors(err, E.Cause(sErr, "subscription ", subConfig.Name))
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func getSubscription(ctx context.Context, config *Config, subConfig subscriptionConfig, dialer N.Dialer) (*S.Result, error) {
+	if len(subConfig.URL) > 0 {
+		clientOptions := config.clientOptions(subConfig)
+		clientOptions.Dialer = dialer
+		client, err := S.NewClient(clientOptions)
+		if err != nil {
+			return nil, err
+		}
+		return client.Get(ctx, subConfig.URL)
+	}
+
+	var outbounds []option.Outbound
+	var err error
+	if subConfig.Content != "" {
+		outbounds, err = S.Get(ctx, subConfig.Content)
+	} else if subConfig.Path != "" {
+		outbounds, err = S.GetPath(ctx, config.resolvePath(subConfig.Path))
+	} else if len(subConfig.Command) > 0 {
+		outbounds, err = S.GetCommand(ctx, S.CommandOptions{
+			Args:    subConfig.Command,
+			Dir:     config.dir,
+			Env:     subConfig.CommandEnv,
+			Timeout: subConfig.CommandTimeout,
+		})
+	} else {
+		err = errors.New("empty url, content, path and command")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &S.Result{Outbounds: outbounds}, nil
 }
 
 func marshal(list any) (results []string) {
@@ -0,0 +1,87 @@
+package tools_generate
+
+import (
+	"net/url"
+	"time"
+
+	E "github.com/sagernet/sing/common/exceptions"
+)
+
+type urlTestConfig struct {
+	URL                       string        `toml:"url"`
+	Interval                  time.Duration `toml:"interval"`
+	Tolerance                 uint16        `toml:"tolerance"`
+	IdleTimeout               time.Duration `toml:"idle_timeout"`
+	InterruptExistConnections *bool         `toml:"interrupt_exist_connections"`
+}
+
+// merge returns c with the fields set in override replaced.
+func (c urlTestConfig) merge(override *urlTestConfig) urlTestConfig {
+	if override == nil {
+		return c
+	}
+	if override.URL != "" {
+		c.URL = override.URL
+	}
+	if override.Interval != 0 {
+		c.Interval = override.Interval
+	}
+	if override.Tolerance != 0 {
+		c.Tolerance = override.Tolerance
+	}
+	if override.IdleTimeout != 0 {
+		c.IdleTimeout = override.IdleTimeout
+	}
+	if override.InterruptExistConnections != nil {
+		c.InterruptExistConnections = override.InterruptExistConnections
+	}
+	return c
+}
+
+func (c urlTestConfig) check() error {
+	if c.URL != "" {
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			return E.Cause(err, "parse urltest url")
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return E.New("invalid urltest url: ", c.URL)
+		}
+	}
+	if c.Interval < 0 || c.IdleTimeout < 0 {
+		return E.New("negative urltest interval or idle_timeout")
+	}
+	if c.Interval != 0 && c.IdleTimeout != 0 && c.IdleTimeout < c.Interval {
+		return E.New("urltest idle_timeout must not be shorter than interval")
+	}
+	return nil
+}
+
+func (c urlTestConfig) interruptExistConnections() bool {
+	return c.InterruptExistConnections != nil && *c.InterruptExistConnections
+}
+
+// URLTestData is how urltest settings are exposed to the template, for
+// groups written there by hand.
+type URLTestData struct {
+	URL                       string
+	Interval                  string
+	Tolerance                 uint16
+	IdleTimeout               string
+	InterruptExistConnections bool
+}
+
+func (c urlTestConfig) data() URLTestData {
+	data := URLTestData{
+		URL:                       c.URL,
+		Tolerance:                 c.Tolerance,
+		InterruptExistConnections: c.interruptExistConnections(),
+	}
+	if c.Interval > 0 {
+		data.Interval = c.Interval.String()
+	}
+	if c.IdleTimeout > 0 {
+		data.IdleTimeout = c.IdleTimeout.String()
+	}
+	return data
+}
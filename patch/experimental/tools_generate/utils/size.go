@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Size is a byte count which can be written as an integer or as a string
+// with a binary unit, such as "512KB" or "10GB".
+type Size int64
+
+var sizeUnits = []string{"B", "KB", "MB", "GB", "TB", "PB"}
+
+func ParseSize(s string) (Size, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.Replace(s, "IB", "B", 1)
+	if s != "" && strings.ContainsRune("KMGTP", rune(s[len(s)-1])) {
+		s += "B"
+	}
+	for i := len(sizeUnits) - 1; i >= 0; i-- {
+		number, found := strings.CutSuffix(s, sizeUnits[i])
+		if !found {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid size %q", s)
+		}
+		return Size(value * float64(int64(1)<<(10*i))), nil
+	}
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return Size(value), nil
+}
+
+func (s *Size) UnmarshalTOML(data any) error {
+	switch value := data.(type) {
+	case int64:
+		*s = Size(value)
+	case string:
+		size, err := ParseSize(value)
+		if err != nil {
+			return err
+		}
+		*s = size
+	default:
+		return fmt.Errorf("expected size, got %T", data)
+	}
+	return nil
+}
+
+func (s Size) String() string {
+	return FormatBytes(int64(s))
+}
+
+func FormatBytes(n int64) string {
+	value := float64(n)
+	unit := 0
+	for (value >= 1024 || value <= -1024) && unit < len(sizeUnits)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return strconv.FormatInt(n, 10) + sizeUnits[0]
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + sizeUnits[unit]
+}
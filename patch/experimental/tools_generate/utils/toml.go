@@ -0,0 +1,26 @@
+package utils
+
+import "fmt"
+
+// StringList accepts either a single string or an array of strings.
+type StringList []string
+
+func (l *StringList) UnmarshalTOML(data any) error {
+	switch value := data.(type) {
+	case string:
+		*l = []string{value}
+	case []any:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			itemString, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("expected string, got %T", item)
+			}
+			list = append(list, itemString)
+		}
+		*l = list
+	default:
+		return fmt.Errorf("expected string or array of strings, got %T", data)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package tools_generate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sagernet/sing-box/option"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json"
+)
+
+// validateConfig parses content the way sing-box does, then checks that
+// every referenced outbound, rule set and DNS server exists. source is the
+// template the content was rendered from, used to locate syntax errors.
+func validateConfig(ctx context.Context, content []byte, source string) error {
+	_, err := json.UnmarshalExtendedContext[option.Options](ctx, content)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		if errors.As(err, &syntaxError) {
+			return E.Cause(err, locateOffset(content, syntaxError.Offset, source))
+		}
+		return err
+	}
+	content, err = io.ReadAll(json.NewCommentFilter(bytes.NewReader(content)))
+	if err != nil {
+		return err
+	}
+	var object map[string]any
+	err = json.Unmarshal(content, &object)
+	if err != nil {
+		return err
+	}
+	return checkReferences(object)
+}
+
+// locateOffset describes the line of content at offset, and the template
+// line producing it when the line appears there exactly once.
+func locateOffset(content []byte, offset int64, source string) string {
+	lines := strings.Split(string(content[:min(int(offset), len(content))]), "\n")
+	row := len(lines)
+	line := lines[row-1]
+	if rest, _, _ := strings.Cut(string(content[min(int(offset), len(content)):]), "\n"); rest != "" {
+		line += rest
+	}
+	line = strings.TrimSpace(line)
+	location := fmt.Sprint("output line ", row, ": ", line)
+	if source == "" || line == "" {
+		return location
+	}
+	templateRow := 0
+	for idx, templateLine := range strings.Split(source, "\n") {
+		if strings.TrimSpace(templateLine) == line {
+			if templateRow != 0 {
+				return location
+			}
+			templateRow = idx + 1
+		}
+	}
+	if templateRow != 0 {
+		location += fmt.Sprint(" (template line ", templateRow, ")")
+	}
+	return location
+}
+
+type referenceChecker struct {
+	outbounds  map[string]bool
+	ruleSets   map[string]bool
+	dnsServers map[string]bool
+	err        error
+}
+
+func checkReferences(object map[string]any) error {
+	c := &referenceChecker{
+		outbounds:  collectTags(object, "outbounds"),
+		ruleSets:   collectTags(object, "route", "rule_set"),
+		dnsServers: collectTags(object, "dns", "servers"),
+	}
+	for tag := range collectTags(object, "endpoints") {
+		c.outbounds[tag] = true
+	}
+
+	for idx, outbound := range objectList(object, "outbounds") {
+		path := fmt.Sprint("outbounds[", idx, "]")
+		for memberIdx, member := range listOf(outbound["outbounds"]) {
+			c.outbound(fmt.Sprint(path, ".outbounds[", memberIdx, "]"), member)
+		}
+		c.outbound(path+".default", outbound["default"])
+		c.outbound(path+".detour", outbound["detour"])
+		c.resolver(path+".domain_resolver", outbound["domain_resolver"])
+	}
+	for idx, endpoint := range objectList(object, "endpoints") {
+		path := fmt.Sprint("endpoints[", idx, "]")
+		c.outbound(path+".detour", endpoint["detour"])
+		c.resolver(path+".domain_resolver", endpoint["domain_resolver"])
+	}
+
+	route, _ := object["route"].(map[string]any)
+	for idx, rule := range objectList(route, "rules") {
+		c.rule(fmt.Sprint("route.rules[", idx, "]"), rule, "outbound", c.outbound)
+	}
+	c.outbound("route.final", route["final"])
+	c.resolver("route.default_domain_resolver", route["default_domain_resolver"])
+	for idx, ruleSet := range objectList(route, "rule_set") {
+		c.outbound(fmt.Sprint("route.rule_set[", idx, "].download_detour"), ruleSet["download_detour"])
+	}
+
+	dns, _ := object["dns"].(map[string]any)
+	for idx, rule := range objectList(dns, "rules") {
+		c.rule(fmt.Sprint("dns.rules[", idx, "]"), rule, "server", c.dnsServer)
+	}
+	c.dnsServer("dns.final", dns["final"])
+	for idx, server := range objectList(dns, "servers") {
+		path := fmt.Sprint("dns.servers[", idx, "]")
+		c.outbound(path+".detour", server["detour"])
+		c.resolver(path+".domain_resolver", server["domain_resolver"])
+	}
+	return c.err
+}
+
+func (c *referenceChecker) rule(path string, rule map[string]any, targetKey string, checkTarget func(path string, tag any)) {
+	checkTarget(path+"."+targetKey, rule[targetKey])
+	c.ruleSet(path, rule)
+}
+
+func (c *referenceChecker) ruleSet(path string, rule map[string]any) {
+	for idx, tag := range listOf(rule["rule_set"]) {
+		if !c.ruleSets[tag] {
+			c.err = E.Errors(c.err, E.New(path, ".rule_set[", idx, "]: rule set ", tag, " not found"))
+		}
+	}
+	for idx, subRule := range objectList(rule, "rules") {
+		c.ruleSet(fmt.Sprint(path, ".rules[", idx, "]"), subRule)
+	}
+}
+
+func (c *referenceChecker) outbound(path string, tag any) {
+	if tag, isString := tag.(string); isString && tag != "" && !c.outbounds[tag] {
+		c.err = E.Errors(c.err, E.New(path, ": outbound ", tag, " not found"))
+	}
+}
+
+func (c *referenceChecker) dnsServer(path string, tag any) {
+	if tag, isString := tag.(string); isString && tag != "" && !c.dnsServers[tag] {
+		c.err = E.Errors(c.err, E.New(path, ": dns server ", tag, " not found"))
+	}
+}
+
+// resolver checks a domain resolver, given either as a server tag or as an
+// object with a server field.
+func (c *referenceChecker) resolver(path string, resolver any) {
+	if object, isObject := resolver.(map[string]any); isObject {
+		c.dnsServer(path+".server", object["server"])
+		return
+	}
+	c.dnsServer(path, resolver)
+}
+
+func collectTags(object map[string]any, path ...string) map[string]bool {
+	for _, key := range path[:len(path)-1] {
+		object, _ = object[key].(map[string]any)
+	}
+	tags := make(map[string]bool)
+	for _, item := range objectList(object, path[len(path)-1]) {
+		if tag, isString := item["tag"].(string); isString {
+			tags[tag] = true
+		}
+	}
+	return tags
+}
+
+func objectList(object map[string]any, key string) []map[string]any {
+	list, _ := object[key].([]any)
+	objects := make([]map[string]any, 0, len(list))
+	for _, item := range list {
+		if itemObject, isObject := item.(map[string]any); isObject {
+			objects = append(objects, itemObject)
+		}
+	}
+	return objects
+}
+
+// listOf reads a listable option, which may be a single string.
+func listOf(value any) []string {
+	list, _ := stringSlice(value)
+	return list
+}
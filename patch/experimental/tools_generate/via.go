@@ -0,0 +1,111 @@
+package tools_generate
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/sagernet/sing-box/option"
+	E "github.com/sagernet/sing/common/exceptions"
+	"github.com/sagernet/sing/common/json"
+)
+
+// applyVia sets the detour of every node of a subscription with via to the
+// given node or group, then rejects detours leading back to the node they
+// are set on, whether set by via, by override or by the provider.
+func applyVia(ctx context.Context, config *Config, nodeList [][]*node, groupMembers map[string][]string, groupTagByName map[string]string) error {
+	nodeTags := make(map[string]bool)
+	for _, nodes := range nodeList {
+		for _, node := range nodes {
+			nodeTags[node.Tag()] = true
+		}
+	}
+	for idx, subCfg := range config.SubscriptionList {
+		if subCfg.Via == "" || len(nodeList[idx]) == 0 {
+			continue
+		}
+		detour := subCfg.Via
+		if groupTag, loaded := groupTagByName[detour]; loaded {
+			detour = groupTag
+		}
+		_, isGroup := groupMembers[detour]
+		if !nodeTags[detour] && !isGroup {
+			return E.New("subscription ", subCfg.Name, ": via ", subCfg.Via, " not found")
+		}
+		override := &outboundOverride{set: map[string]any{"detour": detour}}
+		for _, node := range nodeList[idx] {
+			outbounds, err := override.apply(ctx, []option.Outbound{node.Outbound})
+			if err != nil {
+				return E.Cause(err, "subscription ", subCfg.Name, ": via")
+			}
+			node.Outbound = outbounds[0]
+		}
+	}
+	return checkDetourCycle(ctx, nodeList, groupMembers)
+}
+
+// checkDetourCycle follows the detour of each node, and the members of each
+// group it passes, looking for a path back to a tag already on it.
+func checkDetourCycle(ctx context.Context, nodeList [][]*node, groupMembers map[string][]string) error {
+	next := make(map[string][]string)
+	var tags []string
+	for _, nodes := range nodeList {
+		for _, node := range nodes {
+			tags = append(tags, node.Tag())
+			if detour := outboundDetour(ctx, node.Outbound); detour != "" {
+				next[node.Tag()] = []string{detour}
+			}
+		}
+	}
+	for tag, members := range groupMembers {
+		next[tag] = members
+	}
+
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[string]int)
+	var visit func(tag string, path []string) error
+	visit = func(tag string, path []string) error {
+		path = append(path, tag)
+		switch state[tag] {
+		case visiting:
+			start := slices.Index(path, tag)
+			return E.New("detour cycle: ", strings.Join(path[start:], " -> "))
+		case visited:
+			return nil
+		}
+		state[tag] = visiting
+		for _, nextTag := range next[tag] {
+			err := visit(nextTag, path)
+			if err != nil {
+				return err
+			}
+		}
+		state[tag] = visited
+		return nil
+	}
+	for _, tag := range tags {
+		err := visit(tag, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func outboundDetour(ctx context.Context, outbound option.Outbound) string {
+	content, err := outbound.MarshalJSONContext(ctx)
+	if err != nil {
+		return ""
+	}
+	var options struct {
+		Detour string `json:"detour"`
+	}
+	err = json.Unmarshal(content, &options)
+	if err != nil {
+		return ""
+	}
+	return options.Detour
+}
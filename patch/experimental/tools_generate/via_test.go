@@ -0,0 +1,62 @@
+package tools_generate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sagernet/sing-box/include"
+	"github.com/sagernet/sing-box/option"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestViaOverrideDetourCycle(t *testing.T) {
+	ctx := include.Context(context.Background())
+	config := &Config{
+		SubscriptionList: []subscriptionConfig{
+			{Name: "a", Override: map[string]any{"detour": "out-b"}},
+			{Name: "b", Via: "out-a"},
+		},
+	}
+	override, err := newOutboundOverride(config.SubscriptionList[0].Override)
+	require.NoError(t, err)
+	outbounds, err := override.apply(ctx, []option.Outbound{relayNode("a-A1", "a").Outbound})
+	require.NoError(t, err)
+	nodeList := [][]*node{
+		{{Outbound: outbounds[0], Remark: "A1"}},
+		{relayNode("b-B1", "b")},
+	}
+	groupMembers := map[string][]string{
+		"out-a": {"a-A1"},
+		"out-b": {"b-B1"},
+	}
+
+	err = applyVia(ctx, config, nodeList, groupMembers, nil)
+	require.ErrorContains(t, err, "detour cycle: a-A1 -> out-b -> b-B1 -> out-a -> a-A1")
+}
+
+func TestViaOverrideDetour(t *testing.T) {
+	ctx := include.Context(context.Background())
+	config := &Config{
+		SubscriptionList: []subscriptionConfig{
+			{Name: "a", Override: map[string]any{"detour": "out-b"}},
+			{Name: "b"},
+		},
+	}
+	override, err := newOutboundOverride(config.SubscriptionList[0].Override)
+	require.NoError(t, err)
+	outbounds, err := override.apply(ctx, []option.Outbound{relayNode("a-A1", "a").Outbound})
+	require.NoError(t, err)
+	nodeList := [][]*node{
+		{{Outbound: outbounds[0], Remark: "A1"}},
+		{relayNode("b-B1", "b")},
+	}
+	groupMembers := map[string][]string{
+		"out-a": {"a-A1"},
+		"out-b": {"b-B1"},
+	}
+
+	require.NoError(t, applyVia(ctx, config, nodeList, groupMembers, nil))
+	require.Equal(t, "out-b", outboundDetour(ctx, nodeList[0][0].Outbound))
+	require.Empty(t, outboundDetour(ctx, nodeList[1][0].Outbound))
+}